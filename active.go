@@ -0,0 +1,95 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type activeJobKey struct {
+	slug  string
+	queue string
+	state string
+}
+
+func (c *checker) checkActiveAll(ctx context.Context) {
+	if c.activeTicker == nil {
+		c.activeTicker = time.NewTicker(c.activeInterval)
+		c.pollActive(ctx) // check right away after ticker setup
+	}
+	defer c.activeTicker.Stop()
+	for {
+		select {
+		case <-c.activeTicker.C:
+			c.pollActive(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pollActive runs checkActiveNow with a deadline of our active interval.
+func (c *checker) pollActive(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, c.activeInterval)
+	defer cancel()
+
+	defer c.observePoll("active", time.Now())
+	c.checkActiveNow(ctx)
+}
+
+// checkActiveNow replaces the travisci_active_* gauges with the builds
+// currently running for our organization.
+func (c *checker) checkActiveNow(ctx context.Context) {
+	builds, resp, err := findActive(ctx, c.client, c.name, &includeOption{Include: includeJobs})
+	if resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		log.Printf("ERROR: %s active builds from travis-ci api: %v", c.name, err)
+		return
+	}
+	builds = c.filterBuilds(builds)
+
+	buildCounts := make(map[string]int)
+	jobCounts := make(map[activeJobKey]int)
+	var oldest time.Duration
+
+	for i := range builds {
+		slug := builds[i].Repository.Slug
+		buildCounts[slug]++
+
+		if start, err := time.Parse(timestampFormat, builds[i].StartedAt); err == nil {
+			if age := time.Since(start); age > oldest {
+				oldest = age
+			}
+		}
+
+		jobs := builds[i].Jobs
+		for k := range jobs {
+			if jobs[k].FinishedAt != "" {
+				continue
+			}
+			jobCounts[activeJobKey{slug: slug, queue: jobs[k].Queue, state: jobs[k].State}]++
+		}
+	}
+
+	var samples []gaugeSample
+	for slug, n := range buildCounts {
+		samples = append(samples, gaugeSample{labels: []string{c.name, slug}, value: float64(n)})
+	}
+	c.metrics.replace(activeBuilds, samples)
+
+	samples = nil
+	for k, n := range jobCounts {
+		samples = append(samples, gaugeSample{labels: []string{c.name, k.slug, k.queue, k.state}, value: float64(n)})
+	}
+	c.metrics.replace(activeJobs, samples)
+
+	c.metrics.set(activeOldestBuildAge, oldest.Seconds(), c.name)
+	c.succeeded("active")
+	c.publish()
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/shuheiktgw/go-travis"
+)
+
+type branchKey struct {
+	slug   string
+	branch string
+}
+
+type branchStatus struct {
+	defaultBranch bool
+
+	// known is false until we've seen a finished build on the branch
+	known  bool
+	passed bool
+
+	// brokenSince is when the branch first went red, zero while it's green
+	brokenSince time.Time
+}
+
+// checkBranches updates the health of every branch (still on GitHub) of slugs
+// and replaces the travisci_branch_* gauges. Repositories we couldn't read keep
+// their branches. It returns false if every repository failed.
+func (c *checker) checkBranches(ctx context.Context, slugs []string) bool {
+	listed := make([][]travis.Branch, len(slugs))
+	read := make([]bool, len(slugs))
+	c.parallel(ctx, len(slugs), func(i int) {
+		branches, resp, err := c.client.Branches.ListByRepoSlug(ctx, slugs[i], &travis.ListBranchesOption{
+			ExistsOnGithub: true,
+			Limit:          100,
+		})
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			log.Printf("ERROR: %s branches for %s from travis-ci api: %v", c.name, slugs[i], err)
+			return // keep what we knew about this repo's branches
+		}
+		listed[i], read[i] = branches, true
+	})
+
+	c.mu.Lock()
+	var broken []branchKey
+	for i, slug := range slugs {
+		if !read[i] {
+			continue
+		}
+		seen := make(map[branchKey]bool)
+		for k := range listed[i] {
+			key := branchKey{slug: slug, branch: listed[i][k].Name}
+			seen[key] = true
+
+			status, exists := c.branches[key]
+			if !exists {
+				status = &branchStatus{}
+				c.branches[key] = status
+			}
+			status.defaultBranch = listed[i][k].DefaultBranch
+			known := status.known
+			status.update(&listed[i][k].LastBuild)
+			if !known && status.known && !status.passed {
+				broken = append(broken, key) // we don't know how long it's been red, i.e. after a restart or reload
+			}
+		}
+		for key := range c.branches {
+			if key.slug == slug && !seen[key] {
+				delete(c.branches, key) // branch was deleted
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	since := make([]time.Time, len(broken))
+	c.parallel(ctx, len(broken), func(i int) {
+		since[i] = c.brokenSince(ctx, broken[i])
+	})
+
+	c.mu.Lock()
+	for i, key := range broken {
+		if status, exists := c.branches[key]; exists && !status.passed && !since[i].IsZero() && since[i].Before(status.brokenSince) {
+			status.brokenSince = since[i]
+		}
+	}
+	var passed, brokenSince []gaugeSample
+	for key, status := range c.branches {
+		if !status.known {
+			continue
+		}
+		labels := []string{key.slug, key.branch, strconv.FormatBool(status.defaultBranch)}
+		if status.passed {
+			passed = append(passed, gaugeSample{labels: labels, value: 1})
+		} else {
+			passed = append(passed, gaugeSample{labels: labels, value: 0})
+			brokenSince = append(brokenSince, gaugeSample{labels: labels, value: float64(status.brokenSince.Unix())})
+		}
+	}
+	c.mu.Unlock()
+
+	c.metrics.replace(branchLastBuildPassed, passed)
+	c.metrics.replace(branchBrokenSince, brokenSince)
+
+	for i := range read {
+		if read[i] {
+			return true
+		}
+	}
+	return len(slugs) == 0
+}
+
+// update records the outcome of a branch's last build, builds which haven't
+// finished (or were canceled) leave the branch's health as it was.
+func (s *branchStatus) update(build *travis.MinimalBuild) {
+	switch build.State {
+	case travis.BuildStatePassed:
+		s.known, s.passed = true, true
+		s.brokenSince = time.Time{}
+
+	case travis.BuildStateFailed, travis.BuildStateErrored:
+		s.known, s.passed = true, false
+		if s.brokenSince.IsZero() {
+			s.brokenSince = time.Now()
+			if finished, err := time.Parse(timestampFormat, build.FinishedAt); err == nil {
+				s.brokenSince = finished
+			}
+		}
+	}
+}
+
+// brokenSince looks through the recent builds of a red branch for when it first
+// went red, the first failed build after the last one which passed. It returns
+// the zero time if the builds can't be read.
+func (c *checker) brokenSince(ctx context.Context, key branchKey) time.Time {
+	builds, resp, err := c.client.Builds.ListByRepoSlug(ctx, key.slug, &travis.BuildsByRepoOption{
+		BranchName: []string{key.branch},
+		State:      []string{travis.BuildStatePassed, travis.BuildStateFailed, travis.BuildStateErrored},
+		Limit:      100,
+		SortBy:     "id:desc",
+	})
+	if resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		log.Printf("ERROR: %s builds of %s branch %s from travis-ci api: %v", c.name, key.slug, key.branch, err)
+		return time.Time{}
+	}
+
+	var since time.Time
+	for i := range builds {
+		if builds[i].State == travis.BuildStatePassed {
+			break
+		}
+		if finished, err := time.Parse(timestampFormat, builds[i].FinishedAt); err == nil {
+			since = finished
+		}
+	}
+	return since
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errBreakerOpen = errors.New("travis-ci api circuit breaker is open")
+
+// circuitBreaker stops calls to the TravisCI API for cooldown once failures
+// requests in a row have failed. After cooldown one request is let through and
+// its result decides if the breaker closes or stays open.
+type circuitBreaker struct {
+	name     string
+	failures int
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	failed    int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow returns errBreakerOpen if a request shouldn't be sent right now.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failed < b.failures {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return errBreakerOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// release lets another request probe the API without recording a result.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// record counts a request as failed or, if ok, closes the breaker.
+func (b *circuitBreaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if ok {
+		if b.failed >= b.failures {
+			log.Printf("%s travis-ci api circuit breaker closed", b.name)
+		}
+		b.failed = 0
+		return
+	}
+	b.failed++
+	if b.failed >= b.failures {
+		if b.failed == b.failures {
+			log.Printf("ERROR: %s travis-ci api circuit breaker opened after %d failed requests", b.name, b.failed)
+		}
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// breakerTransport fails fast while its circuitBreaker is open.
+type breakerTransport struct {
+	breaker *circuitBreaker
+	next    http.RoundTripper
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if req.Context().Err() != nil {
+		// Our caller gave up (i.e. its poll ran out of time or we're shutting
+		// down), which says nothing about TravisCI. Requests timing out are
+		// failures though, their deadline is set below us by timeoutTransport.
+		t.breaker.release()
+		return resp, err
+	}
+	t.breaker.record(err == nil && !temporaryStatus(resp))
+	return resp, err
+}
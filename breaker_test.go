@@ -0,0 +1,149 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	// each step is a request, its outcome and if the breaker should let it through
+	type step struct {
+		ok      bool
+		release bool
+		cooled  bool // cooldown passes before the request
+		allowed bool
+	}
+	cases := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "stays closed below failures",
+			steps: []step{
+				{ok: false, allowed: true},
+				{ok: false, allowed: true},
+				{ok: true, allowed: true},
+				{ok: false, allowed: true},
+				{ok: false, allowed: true},
+				{ok: true, allowed: true},
+			},
+		},
+		{
+			name: "opens after failures",
+			steps: []step{
+				{ok: false, allowed: true},
+				{ok: false, allowed: true},
+				{ok: false, allowed: true},
+				{allowed: false},
+				{allowed: false},
+			},
+		},
+		{
+			name: "closes after probe succeeds",
+			steps: []step{
+				{ok: false, allowed: true},
+				{ok: false, allowed: true},
+				{ok: false, allowed: true},
+				{cooled: true, ok: true, allowed: true},
+				{ok: true, allowed: true},
+			},
+		},
+		{
+			name: "reopens after probe fails",
+			steps: []step{
+				{ok: false, allowed: true},
+				{ok: false, allowed: true},
+				{ok: false, allowed: true},
+				{cooled: true, ok: false, allowed: true},
+				{allowed: false},
+			},
+		},
+		{
+			name: "released probe lets another through",
+			steps: []step{
+				{ok: false, allowed: true},
+				{ok: false, allowed: true},
+				{ok: false, allowed: true},
+				{cooled: true, release: true, allowed: true},
+				{ok: true, allowed: true},
+				{ok: true, allowed: true},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &circuitBreaker{name: "test", failures: 3, cooldown: time.Hour}
+			for i, s := range tc.steps {
+				if s.cooled {
+					b.openUntil = time.Now().Add(-time.Second)
+				}
+				err := b.allow()
+				if allowed := err == nil; allowed != s.allowed {
+					t.Fatalf("step %d: allowed = %v, expected %v", i, allowed, s.allowed)
+				}
+				if err != nil {
+					continue
+				}
+				if s.release {
+					b.release()
+				} else {
+					b.record(s.ok)
+				}
+			}
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+func TestBreakerTransport(t *testing.T) {
+	cases := []struct {
+		name     string
+		resp     *http.Response
+		err      error
+		canceled bool // our caller gave up
+		failed   int
+	}{
+		{name: "ok", resp: &http.Response{StatusCode: http.StatusOK}},
+		{name: "not found", resp: &http.Response{StatusCode: http.StatusNotFound}},
+		{name: "server error", resp: &http.Response{StatusCode: http.StatusBadGateway}, failed: 1},
+		{name: "timeout", err: context.DeadlineExceeded, failed: 1},
+		{name: "network error", err: errors.New("connection refused"), failed: 1},
+		{name: "canceled", err: context.Canceled, canceled: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &circuitBreaker{name: "test", failures: 3, cooldown: time.Hour}
+			transport := &breakerTransport{
+				breaker: b,
+				next: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+					return tc.resp, tc.err
+				}),
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			if tc.canceled {
+				cancel()
+			}
+			defer cancel()
+			req, err := http.NewRequest(http.MethodGet, "https://api.travis-ci.com/builds", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transport.RoundTrip(req.WithContext(ctx))
+			if b.failed != tc.failed {
+				t.Errorf("got %d failures, expected %d", b.failed, tc.failed)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type cacheInventory struct {
+	count        int
+	size         int64
+	lastModified time.Time
+}
+
+func (c *checker) checkCachesAll(ctx context.Context) {
+	select {
+	case <-c.ready: // wait until we know our repositories
+	case <-ctx.Done():
+		return
+	}
+
+	if c.cachesTicker == nil {
+		c.cachesTicker = time.NewTicker(c.cachesInterval)
+		c.pollCaches(ctx) // check right away after ticker setup
+	}
+	defer c.cachesTicker.Stop()
+	for {
+		select {
+		case <-c.cachesTicker.C:
+			c.pollCaches(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pollCaches runs checkCachesNow with a deadline of our caches interval.
+func (c *checker) pollCaches(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, c.cachesInterval)
+	defer cancel()
+
+	defer c.observePoll("caches", time.Now())
+	c.checkCachesNow(ctx)
+}
+
+// checkCachesNow replaces the travisci_cache* gauges with the caches of each
+// of our repositories. Repositories we couldn't read keep their last caches.
+func (c *checker) checkCachesNow(ctx context.Context) {
+	slugs := c.repoSlugs()
+	read := 0
+	for _, slug := range slugs {
+		if ctx.Err() != nil {
+			break // out of time, skip the rest
+		}
+		caches, resp, err := listCaches(ctx, c.client, slug)
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			log.Printf("ERROR: %s caches for %s from travis-ci api: %v", c.name, slug, err)
+			continue // keep what we knew about this repo's caches
+		}
+		read++
+
+		inventory := make(map[string]*cacheInventory)
+		for i := range caches {
+			inv, exists := inventory[caches[i].Branch]
+			if !exists {
+				inv = &cacheInventory{}
+				inventory[caches[i].Branch] = inv
+			}
+			inv.count++
+			inv.size += caches[i].Size
+			if t, err := time.Parse(time.RFC3339, caches[i].LastModified); err == nil && t.After(inv.lastModified) {
+				inv.lastModified = t
+			}
+		}
+
+		var count, size, lastModified []gaugeSample
+		for branch, inv := range inventory {
+			labels := []string{slug, branch}
+			count = append(count, gaugeSample{labels: labels, value: float64(inv.count)})
+			size = append(size, gaugeSample{labels: labels, value: float64(inv.size)})
+			if !inv.lastModified.IsZero() {
+				lastModified = append(lastModified, gaugeSample{labels: labels, value: float64(inv.lastModified.Unix())})
+			}
+		}
+		c.cacheGauges.set(cachesCount, slug, count)
+		c.cacheGauges.set(cacheSizeBytes, slug, size)
+		c.cacheGauges.set(cacheLastModified, slug, lastModified)
+	}
+	c.cacheGauges.retain(slugs)
+
+	for _, f := range []*family{cachesCount, cacheSizeBytes, cacheLastModified} {
+		c.metrics.replace(f, c.cacheGauges.samples(f))
+	}
+	if read > 0 || len(slugs) == 0 {
+		c.succeeded("caches")
+	}
+	c.publish()
+}
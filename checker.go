@@ -0,0 +1,582 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shuheiktgw/go-travis"
+)
+
+type checker struct {
+	name   string
+	client *travis.Client
+
+	// cfg is swapped when a reload only changes crons or settings, so it's
+	// guarded by cfgMu.
+	cfgMu sync.Mutex
+	cfg   *config
+
+	// owner is who we report on, builds from other owners the token can
+	// see are ignored.
+	owner *travis.Owner
+
+	// filter picks the repositories we report on, nil reports on all of them
+	filter *repoMatcher
+
+	t        *time.Ticker
+	interval time.Duration
+
+	activeTicker   *time.Ticker
+	activeInterval time.Duration
+
+	cachesTicker   *time.Ticker
+	cachesInterval time.Duration
+
+	reposTicker   *time.Ticker
+	reposInterval time.Duration
+
+	// builds, jobs and stages hold the ids we've already observed as finished (and
+	// when), so each one is only recorded once even though listing builds keeps
+	// returning them, but again once it's restarted and finishes another time.
+	builds seen
+	jobs   seen
+	stages seen
+
+	// highWater is the newest build id we've read of each repository and
+	// unfinished holds the builds which were still running (and when they
+	// started), so each check only reads new builds and looks up the ones
+	// we're waiting on.
+	highWater  map[string]uint
+	unfinished seen
+
+	// nextSlug is the repository our last check ran out of time at, so the
+	// next one starts listing builds there.
+	nextSlug string
+
+	// started holds the job ids whose queue wait we've observed
+	started seen
+
+	// requests holds the build request ids we've already counted and
+	// lastRejected is the newest rejected request of each repository, both
+	// are guarded by mu as our repos loop counts them.
+	requests     seen
+	lastRejected map[string]time.Time
+
+	// repos holds the slug of every repository of our owner we report on, it's
+	// guarded by mu as the caches and repos loops read it.
+	mu    sync.Mutex
+	repos map[string]bool
+
+	// ready is closed once we've listed all of our repositories
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	// branches is the last known health of each branch in repos, it's also
+	// guarded by mu as both our builds and repos loops update it.
+	branches map[branchKey]*branchStatus
+
+	// cacheGauges, cronGauges and settingGauges are the last samples read for
+	// each repository by our caches and repos loops.
+	cacheGauges   repoGauges
+	cronGauges    repoGauges
+	settingGauges repoGauges
+
+	// metrics is written by each of our loops, which then publish a
+	// snapshot of it for our collector to serve.
+	metrics   *metricSet
+	publishMu sync.Mutex
+	snap      atomic.Value // *snapshot
+}
+
+func newChecker(org organization, owner *travis.Owner, client *travis.Client, cfg *config, interval, activeInterval, cachesInterval, reposInterval time.Duration) *checker {
+	return &checker{
+		name:           org.Name,
+		client:         client,
+		cfg:            cfg,
+		owner:          owner,
+		filter:         org.matcher,
+		interval:       interval,
+		activeInterval: activeInterval,
+		cachesInterval: cachesInterval,
+		reposInterval:  reposInterval,
+		builds:         make(seen),
+		jobs:           make(seen),
+		stages:         make(seen),
+		highWater:      make(map[string]uint),
+		unfinished:     make(seen),
+		started:        make(seen),
+
+		requests:     make(seen),
+		lastRejected: make(map[string]time.Time),
+
+		repos: make(map[string]bool),
+		ready: make(chan struct{}),
+
+		branches: make(map[branchKey]*branchStatus),
+
+		cacheGauges:   make(repoGauges),
+		cronGauges:    make(repoGauges),
+		settingGauges: make(repoGauges),
+
+		metrics: newMetricSet(),
+	}
+}
+
+// start runs each of our loops until ctx is canceled, wg is done once they've all returned.
+func (c *checker) start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		c.checkAll(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		c.checkActiveAll(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		c.checkCachesAll(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		c.checkReposAll(ctx)
+	}()
+}
+
+func (c *checker) checkAll(ctx context.Context) {
+	if c.t == nil {
+		c.t = time.NewTicker(c.interval)
+		c.poll(ctx) // check domains right away after ticker setup
+	}
+	defer c.t.Stop()
+	for {
+		select {
+		case <-c.t.C:
+			c.poll(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poll runs checkNow with a deadline so a large organization can't overrun our interval.
+func (c *checker) poll(ctx context.Context) {
+	timeout := c.config().API.PollTimeout
+	if timeout == 0 {
+		timeout = c.interval
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	defer c.observePoll("builds", time.Now())
+	c.checkNow(ctx)
+}
+
+func (c *checker) checkNow(ctx context.Context) {
+	repos, err := c.listRepos(ctx)
+	if err != nil {
+		log.Printf("ERROR: %s repositories from travis-ci api: %v", c.name, err)
+	}
+
+	c.mu.Lock()
+	if err == nil {
+		c.repos = make(map[string]bool) // forget repositories our owner no longer has
+	}
+	for i := range repos {
+		c.repos[repos[i].Slug] = true
+	}
+	c.mu.Unlock()
+	if err == nil {
+		// Our other loops only start once they know every repository, otherwise
+		// they'd count a failed listing as an owner without repositories.
+		c.readyOnce.Do(func() { close(c.ready) })
+	}
+
+	// Builds are listed for each of our repositories rather than read from every
+	// build the token can see, so other owners can't crowd ours out of our pages.
+	// Only repositories which started a build since we last read them are listed,
+	// a restarted build keeps its id so it's looked up with our unfinished ones.
+	var slugs []string
+	for i := range repos {
+		current := repos[i].CurrentBuild
+		switch {
+		case current == nil:
+		case current.Id > c.highWater[repos[i].Slug]:
+			slugs = append(slugs, repos[i].Slug)
+		case c.restarted(current):
+			c.unfinished[current.Id] = time.Now()
+		}
+	}
+	sort.Strings(slugs)
+	slugs = startFrom(slugs, c.nextSlug)
+
+	listed := make([][]buildWithJobs, len(slugs))
+	tried := make([]bool, len(slugs))
+	read := make([]bool, len(slugs))
+	c.parallel(ctx, len(slugs), func(i int) {
+		tried[i] = true
+		builds, err := c.listBuilds(ctx, slugs[i], c.highWater[slugs[i]])
+		listed[i] = builds
+		if err != nil {
+			log.Printf("ERROR: %s builds for %s from travis-ci api: %v", c.name, slugs[i], err)
+			return
+		}
+		read[i] = true
+	})
+
+	complete := err == nil
+	c.nextSlug = ""
+	var builds []buildWithJobs
+	for i := range slugs {
+		builds = append(builds, listed[i]...)
+		if !tried[i] && c.nextSlug == "" {
+			c.nextSlug = slugs[i] // we ran out of time, start here next time
+		}
+		if !read[i] {
+			complete = false
+			continue
+		}
+		// Only move a repository's high-water mark once we've read every new build,
+		// otherwise builds on the pages we couldn't read would be skipped next time.
+		for k := range listed[i] {
+			if listed[i][k].Id > c.highWater[slugs[i]] {
+				c.highWater[slugs[i]] = listed[i][k].Id
+			}
+		}
+	}
+	builds = append(builds, c.refreshUnfinished(ctx, builds)...)
+	builds = c.filterBuilds(builds)
+
+	waiting := make(map[waitingKey]int)
+	finished := make(map[string]bool)
+	for i := range builds {
+		c.recordJobs(&builds[i], waiting)
+		c.recordStages(&builds[i])
+		if c.recordBuild(&builds[i]) {
+			finished[builds[i].Repository.Slug] = true
+		}
+
+		if builds[i].FinishedAt == "" {
+			if !c.unfinished.has(builds[i].Id) {
+				c.unfinished[builds[i].Id] = buildStarted(&builds[i])
+			}
+		} else {
+			delete(c.unfinished, builds[i].Id)
+		}
+	}
+	c.setWaiting(waiting)
+
+	c.prune()
+
+	// Only a finished build can change a branch's health, our repos loop checks
+	// every branch (i.e. deleted ones) less often.
+	var changed []string
+	for slug := range finished {
+		changed = append(changed, slug)
+	}
+	sort.Strings(changed)
+	c.checkBranches(ctx, changed)
+
+	if complete {
+		c.succeeded("builds")
+	}
+	c.publish()
+}
+
+// runningBuildStates are the states of a build which hasn't finished
+var runningBuildStates = map[string]bool{
+	travis.BuildStateCreated:  true,
+	travis.BuildStateReceived: true,
+	travis.BuildStateStarted:  true,
+}
+
+// restarted reports if current, a build we've already read, has been restarted
+// since. Restarting a build makes it the current build of its repository again.
+func (c *checker) restarted(current *travis.MinimalBuild) bool {
+	if c.unfinished.has(current.Id) {
+		return false // already looking it up
+	}
+	if runningBuildStates[current.State] {
+		return true
+	}
+	return c.builds.has(current.Id) && !c.builds.recorded(current.Id, current.FinishedAt)
+}
+
+// publish makes a snapshot of our metrics available to scrapes.
+func (c *checker) publish() {
+	c.publishMu.Lock()
+	defer c.publishMu.Unlock()
+
+	c.snap.Store(c.metrics.snapshot())
+}
+
+// config returns the config we're currently checking with.
+func (c *checker) config() *config {
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+
+	return c.cfg
+}
+
+// setConfig swaps the config we check with, cfg can only differ from our
+// current one by its crons or settings.
+func (c *checker) setConfig(cfg *config) {
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+
+	c.cfg = cfg
+}
+
+// latest returns our most recently published snapshot, or nil before our first check.
+func (c *checker) latest() *snapshot {
+	snap, _ := c.snap.Load().(*snapshot)
+	return snap
+}
+
+// listBuilds pages through the builds of slug newer than after until it reaches
+// the last page, builds older than our lookback window or our maximum page count.
+// Builds from pages read before an error are still returned.
+func (c *checker) listBuilds(ctx context.Context, slug string, after uint) ([]buildWithJobs, error) {
+	opt := &buildsOption{
+		BuildsOption: travis.BuildsOption{
+			Limit:  c.config().Builds.PageSize,
+			SortBy: "id:desc",
+		},
+		Include: includeJobs,
+	}
+	if opt.Limit == 0 {
+		opt.Limit = defaultBuildsPageSize
+	}
+	maxPages := c.config().Builds.MaxPages
+	if maxPages == 0 {
+		maxPages = defaultBuildsMaxPages
+	}
+	lookback := c.config().Builds.Lookback
+	if lookback == 0 {
+		lookback = defaultBuildsLookback
+	}
+	cutoff := time.Now().Add(-1 * lookback)
+
+	var out []buildWithJobs
+	for page := 0; page < maxPages; page++ {
+		builds, pagination, resp, err := listRepoBuilds(ctx, c.client, slug, opt)
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			return out, err
+		}
+		for i := range builds {
+			if builds[i].Id <= after {
+				return out, nil // caught up with our last check
+			}
+			out = append(out, builds[i])
+		}
+
+		if pagination.IsLast || pagination.Next == nil || len(builds) == 0 {
+			break
+		}
+		if started, err := time.Parse(timestampFormat, builds[len(builds)-1].StartedAt); err == nil && started.Before(cutoff) {
+			break
+		}
+		opt.Offset = pagination.Next.Offset
+	}
+	return out, nil
+}
+
+// listRepos pages through the repositories of our owner, returning the ones we report on.
+func (c *checker) listRepos(ctx context.Context) ([]repository, error) {
+	if c.owner == nil {
+		return nil, nil
+	}
+	pageSize := c.config().Builds.PageSize
+	if pageSize == 0 {
+		pageSize = defaultBuildsPageSize
+	}
+
+	var out []repository
+	opt := &reposOption{Limit: pageSize, Include: includeCurrentBuild}
+	for {
+		repos, page, resp, err := listOwnerRepos(ctx, c.client, c.owner.Login, opt)
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			return out, err
+		}
+		for i := range repos {
+			if c.filter.allowed(repos[i]) {
+				out = append(out, repos[i])
+			}
+		}
+		if page == nil || page.IsLast || page.Next == nil || len(repos) == 0 {
+			return out, nil
+		}
+		opt.Offset = page.Next.Offset
+	}
+}
+
+// refreshUnfinished looks up every build which hadn't finished as of our last
+// check and isn't in listed.
+func (c *checker) refreshUnfinished(ctx context.Context, listed []buildWithJobs) []buildWithJobs {
+	seen := make(map[uint]bool)
+	for i := range listed {
+		seen[listed[i].Id] = true
+	}
+	var ids []uint
+	for id := range c.unfinished {
+		if !seen[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	builds := make([]*buildWithJobs, len(ids))
+	gone := make([]bool, len(ids))
+	c.parallel(ctx, len(ids), func(i int) {
+		b, resp, err := findBuild(ctx, c.client, ids[i], &includeOption{Include: includeJobs})
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				gone[i] = true
+				return
+			}
+			log.Printf("ERROR: %s build %d from travis-ci api: %v", c.name, ids[i], err)
+			return
+		}
+		builds[i] = b
+	})
+
+	var out []buildWithJobs
+	for i := range ids {
+		if gone[i] {
+			delete(c.unfinished, ids[i]) // build is gone, stop asking for it
+		}
+		if builds[i] != nil {
+			out = append(out, *builds[i])
+		}
+	}
+	return out
+}
+
+// parallel calls fn for each index up to n from our pool of workers, skipping
+// whatever is left once ctx is done.
+func (c *checker) parallel(ctx context.Context, n int, fn func(i int)) {
+	workers := c.config().API.Concurrency
+	if workers <= 0 {
+		workers = defaultAPIConcurrency
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// startFrom returns sorted (a sorted list of slugs) starting at the first slug
+// from or after it, wrapping around to the ones before.
+func startFrom(sorted []string, from string) []string {
+	i := sort.SearchStrings(sorted, from)
+	out := make([]string, 0, len(sorted))
+	out = append(out, sorted[i:]...)
+	return append(out, sorted[:i]...)
+}
+
+// repoSlugs returns every repository we report on, sorted by slug.
+func (c *checker) repoSlugs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var out []string
+	for slug := range c.repos {
+		out = append(out, slug)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// recordJobs counts (and observes the duration of) each job of build which has
+// finished since our last check. Jobs still waiting for a worker are tallied
+// into waiting.
+func (c *checker) recordJobs(build *buildWithJobs, waiting map[waitingKey]int) {
+	cutoff := c.retentionCutoff()
+	for k := range build.Jobs {
+		job := &build.Jobs[k]
+		if waitingStates[job.State] {
+			waiting[waitingKey{queue: job.Queue, age: queueAge(job.CreatedAt)}]++
+		}
+		c.recordQueueWait(build, job)
+		if job.FinishedAt == "" || c.jobs.recorded(job.Id, job.FinishedAt) {
+			continue // can't measure job duration if it's not finished, or already observed
+		}
+		finished := happenedAt(job.FinishedAt)
+		if finished.Before(cutoff) {
+			continue // older than our retention
+		}
+		c.jobs[job.Id] = finished
+		c.metrics.inc(jobsTotal, build.Repository.Slug, job.State, strconv.FormatBool(job.AllowFailure), build.Branch.Name, job.Queue)
+
+		dur, err := duration(job.StartedAt, job.FinishedAt)
+		if err != nil {
+			jobsSkipped.WithLabelValues(c.name).Inc()
+			continue
+		}
+		c.metrics.observe(jobDurations, dur.Seconds(), build.Repository.Slug, build.Branch.Name, build.EventType, job.State, job.Queue)
+	}
+}
+
+// recordBuild counts build and observes its duration once it has finished,
+// returning true if it hadn't been counted before.
+func (c *checker) recordBuild(build *buildWithJobs) bool {
+	if build.FinishedAt == "" || c.builds.recorded(build.Id, build.FinishedAt) {
+		return false
+	}
+	finished := happenedAt(build.FinishedAt)
+	if finished.Before(c.retentionCutoff()) {
+		return false // older than our retention
+	}
+	c.builds[build.Id] = finished
+	c.metrics.inc(buildsTotal, build.Repository.Slug, build.Branch.Name, build.EventType, build.State)
+
+	dur, err := duration(build.StartedAt, build.FinishedAt)
+	if err == nil {
+		c.metrics.observe(buildDurations, dur.Seconds(), build.Repository.Slug, build.Branch.Name, build.EventType, build.State)
+	}
+	return true
+}
+
+// duration returns the time between two TravisCI timestamps.
+func duration(started, finished string) (time.Duration, error) {
+	start, err := time.Parse(timestampFormat, started)
+	if err != nil {
+		return 0, err
+	}
+	end, err := time.Parse(timestampFormat, finished)
+	if err != nil {
+		return 0, err
+	}
+	return end.Sub(start), nil
+}
@@ -0,0 +1,291 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shuheiktgw/go-travis"
+)
+
+const testPageSize = 2
+
+// fakeTravis serves a single repository, o/r, along with pages of its builds.
+type fakeTravis struct {
+	mu       sync.Mutex
+	current  string          // current build of o/r, empty for none
+	pages    [][]string      // builds on each page of /repo/o%2Fr/builds
+	failPage int             // page (from 1) answered with an error, 0 for none
+	builds   map[uint]string // builds served by /build/{id}
+}
+
+func (f *fakeTravis) set(fn func(f *fakeTravis)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(f)
+}
+
+func (f *fakeTravis) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/owner/o/repos":
+		current := f.current
+		if current == "" {
+			current = "null"
+		}
+		fmt.Fprintf(w, `{"@pagination":{"is_last":true},"repositories":[{"slug":"o/r","owner":{"id":7},"current_build":%s}]}`, current)
+
+	case r.URL.EscapedPath() == "/repo/o%2Fr/builds":
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		page := offset / testPageSize
+		if page+1 == f.failPage {
+			http.Error(w, `{"@type":"error","error_message":"bad page"}`, http.StatusBadRequest)
+			return
+		}
+		var builds []string
+		if page < len(f.pages) {
+			builds = f.pages[page]
+		}
+		next := "null"
+		if page+1 < len(f.pages) {
+			next = fmt.Sprintf(`{"offset":%d}`, offset+testPageSize)
+		}
+		fmt.Fprintf(w, `{"@pagination":{"is_last":%v,"next":%s},"builds":[%s]}`, next == "null", next, strings.Join(builds, ","))
+
+	case strings.HasPrefix(r.URL.Path, "/build/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/build/"))
+		build, exists := f.builds[uint(id)]
+		if !exists {
+			http.Error(w, `{"@type":"error","error_type":"not_found"}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, build)
+
+	default:
+		fmt.Fprint(w, `{}`)
+	}
+}
+
+// testBuild returns a build of o/r (with a single job) as the TravisCI API does.
+func testBuild(id uint, state, startedAt, finishedAt string) string {
+	return fmt.Sprintf(`{"id":%d,"state":%q,"event_type":"push","started_at":%q,"finished_at":%q,"branch":{"name":"master"},"repository":{"slug":"o/r","owner":{"id":7}},`+
+		`"jobs":[{"id":%d,"state":%q,"queue":"linux","created_at":%q,"started_at":%q,"finished_at":%q}]}`,
+		id, state, startedAt, finishedAt, 10*id, state, startedAt, startedAt, finishedAt)
+}
+
+func newTestChecker(t *testing.T, api *fakeTravis, cfg *config) (*checker, func()) {
+	t.Helper()
+
+	srv := httptest.NewServer(api)
+	cfg.Builds.PageSize = testPageSize
+	client, err := newClient(organization{Name: "o", Token: "secret", APIURL: srv.URL}, cfg)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	c := newChecker(organization{Name: "o"}, &travis.Owner{Id: 7, Login: "o"}, client, cfg, time.Minute, time.Minute, time.Minute, time.Minute)
+	return c, srv.Close
+}
+
+// total sums every series of f.
+func total(c *checker, f *family) float64 {
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+
+	var n float64
+	for _, s := range c.metrics.series[f] {
+		n += s.value
+	}
+	return n
+}
+
+func TestChecker_listBuilds(t *testing.T) {
+	now := time.Now().UTC()
+	build := func(id uint) string {
+		started := now.Add(-time.Duration(6-id) * time.Hour)
+		return testBuild(id, "passed", started.Format(timestampFormat), started.Add(time.Minute).Format(timestampFormat))
+	}
+	pages := [][]string{{build(6), build(5)}, {build(4), build(3)}, {build(2), build(1)}}
+
+	cases := []struct {
+		name     string
+		after    uint
+		failPage int
+		builds   builds
+
+		want    []uint
+		invalid bool
+	}{
+		{
+			name: "every page",
+			want: []uint{6, 5, 4, 3, 2, 1},
+		},
+		{
+			name:  "stops at after",
+			after: 3,
+			want:  []uint{6, 5, 4},
+		},
+		{
+			name:     "partial page error",
+			failPage: 2,
+			want:     []uint{6, 5},
+			invalid:  true,
+		},
+		{
+			name:   "lookback cutoff",
+			builds: builds{Lookback: 90 * time.Minute},
+			want:   []uint{6, 5, 4, 3},
+		},
+		{
+			name:   "max pages",
+			builds: builds{MaxPages: 1},
+			want:   []uint{6, 5},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &fakeTravis{pages: pages, failPage: tc.failPage}
+			c, done := newTestChecker(t, api, &config{Builds: tc.builds})
+			defer done()
+
+			out, err := c.listBuilds(context.Background(), "o/r", tc.after)
+			if tc.invalid != (err != nil) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			var got []uint
+			for i := range out {
+				got = append(got, out[i].Id)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, expected %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestChecker_refreshUnfinished(t *testing.T) {
+	now := time.Now().UTC().Format(timestampFormat)
+	api := &fakeTravis{
+		builds: map[uint]string{
+			1: testBuild(1, "passed", now, now),
+			3: testBuild(3, "started", now, ""),
+		},
+	}
+	c, done := newTestChecker(t, api, &config{})
+	defer done()
+
+	c.unfinished[1] = time.Now() // finished since
+	c.unfinished[2] = time.Now() // deleted since
+	c.unfinished[3] = time.Now() // listed already
+
+	out := c.refreshUnfinished(context.Background(), []buildWithJobs{{Build: travis.Build{Id: 3}}})
+	if len(out) != 1 || out[0].Id != 1 || out[0].FinishedAt != now {
+		t.Errorf("got %#v, expected only build 1 as finished", out)
+	}
+	if c.unfinished.has(2) {
+		t.Error("expected build 2 to no longer be looked up")
+	}
+	if !c.unfinished.has(1) || !c.unfinished.has(3) {
+		t.Errorf("expected builds 1 and 3 to still be unfinished: %v", c.unfinished)
+	}
+}
+
+func TestChecker_checkNow__partial(t *testing.T) {
+	now := time.Now().UTC().Format(timestampFormat)
+	api := &fakeTravis{
+		current:  `{"id":3,"state":"passed"}`,
+		pages:    [][]string{{testBuild(3, "passed", now, now), testBuild(2, "passed", now, now)}, {testBuild(1, "passed", now, now)}},
+		failPage: 2,
+	}
+	c, done := newTestChecker(t, api, &config{})
+	defer done()
+
+	c.checkNow(context.Background())
+	if n := c.highWater["o/r"]; n != 0 {
+		t.Errorf("high-water mark moved to %d without reading every page", n)
+	}
+	if n := total(c, buildsTotal); n != 2 {
+		t.Errorf("got %v builds, expected 2", n)
+	}
+
+	api.set(func(f *fakeTravis) { f.failPage = 0 })
+	c.checkNow(context.Background())
+	if n := c.highWater["o/r"]; n != 3 {
+		t.Errorf("got high-water mark %d, expected 3", n)
+	}
+	if n := total(c, buildsTotal); n != 3 {
+		t.Errorf("got %v builds, expected 3", n)
+	}
+
+	c.checkNow(context.Background()) // nothing new to list
+	if n := total(c, buildsTotal); n != 3 {
+		t.Errorf("got %v builds, expected 3", n)
+	}
+}
+
+func TestChecker_checkNow__restarted(t *testing.T) {
+	ts := func(ago time.Duration) string {
+		return time.Now().Add(-ago).UTC().Format(timestampFormat)
+	}
+	first := testBuild(1, "failed", ts(20*time.Minute), ts(10*time.Minute))
+	api := &fakeTravis{
+		current: fmt.Sprintf(`{"id":1,"state":"failed","finished_at":%q}`, ts(10*time.Minute)),
+		pages:   [][]string{{first}},
+		builds:  map[uint]string{1: first},
+	}
+	c, done := newTestChecker(t, api, &config{})
+	defer done()
+
+	c.checkNow(context.Background())
+	if n := total(c, buildsTotal); n != 1 {
+		t.Fatalf("got %v builds, expected 1", n)
+	}
+
+	// restarted, it keeps its id and is running again
+	running := testBuild(1, "started", ts(time.Minute), "")
+	api.set(func(f *fakeTravis) {
+		f.current = `{"id":1,"state":"started"}`
+		f.builds[1] = running
+	})
+	c.checkNow(context.Background())
+	if !c.unfinished.has(1) {
+		t.Error("expected restarted build to be looked up until it finishes")
+	}
+	if n := total(c, buildsTotal); n != 1 {
+		t.Errorf("got %v builds, expected 1", n)
+	}
+
+	finished := ts(0)
+	api.set(func(f *fakeTravis) {
+		f.current = fmt.Sprintf(`{"id":1,"state":"passed","finished_at":%q}`, finished)
+		f.builds[1] = testBuild(1, "passed", ts(time.Minute), finished)
+	})
+	c.checkNow(context.Background())
+	if n := total(c, buildsTotal); n != 2 {
+		t.Errorf("got %v builds, expected 2", n)
+	}
+	if n := total(c, jobsTotal); n != 2 {
+		t.Errorf("got %v jobs, expected 2", n)
+	}
+	if c.unfinished.has(1) {
+		t.Error("expected finished build to no longer be looked up")
+	}
+
+	c.checkNow(context.Background())
+	if n := total(c, buildsTotal); n != 2 {
+		t.Errorf("got %v builds after nothing changed, expected 2", n)
+	}
+}
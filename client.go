@@ -0,0 +1,202 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shuheiktgw/go-travis"
+)
+
+// newClient returns a TravisCI API client for org. Requests are rate limited
+// per token, retried, guarded by a circuit breaker and time out according to cfg.
+// Each request is instrumented, including retries.
+func newClient(org organization, cfg *config) (*travis.Client, error) {
+	baseURL := travis.ApiComUrl
+	switch {
+	case org.APIURL != "":
+		baseURL = org.APIURL
+		if !strings.HasSuffix(baseURL, "/") {
+			baseURL += "/"
+		}
+	case org.UseOrg:
+		baseURL = travis.ApiOrgUrl
+	}
+	client := travis.NewClient(baseURL, "") // tokenTransport authenticates each request
+
+	// A token_command which hangs can't hold up our startup or a reload
+	ctx, cancel := context.WithTimeout(context.Background(), durationOrDefault(cfg.API.RequestTimeout, defaultAPIRequestTimeout))
+	defer cancel()
+
+	source := newTokenSource(org)
+	if _, err := source.token(ctx); err != nil {
+		return nil, fmt.Errorf("organizations.%s: reading token: %v", org.Name, err)
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if org.TLS != (tlsConfig{}) {
+		conf, err := org.TLS.load()
+		if err != nil {
+			return nil, fmt.Errorf("organizations.%s.tls: %v", org.Name, err)
+		}
+		transport = newTransport(conf)
+	}
+	if client.BaseURL.Path != "/" {
+		transport = &basePathTransport{
+			base: client.BaseURL,
+			next: transport,
+		}
+	}
+	transport = &tokenTransport{
+		source: source,
+		next:   transport,
+	}
+	transport = &instrumentTransport{
+		org:  org.Name,
+		base: client.BaseURL.EscapedPath(),
+		next: transport,
+	}
+	if cfg.API.RequestsPerSecond > 0 {
+		transport = &rateLimitTransport{
+			limiter: limiterFor(source.key(), cfg.API.RequestsPerSecond, cfg.API.Burst),
+			next:    transport,
+			maxHold: durationOrDefault(cfg.API.Retry.MaxBackoff, defaultRetryMaxBackoff),
+		}
+	}
+	transport = &retryTransport{
+		attempts:   intOrDefault(cfg.API.Retry.Attempts, defaultRetryAttempts),
+		minBackoff: durationOrDefault(cfg.API.Retry.MinBackoff, defaultRetryMinBackoff),
+		maxBackoff: durationOrDefault(cfg.API.Retry.MaxBackoff, defaultRetryMaxBackoff),
+		next:       transport,
+	}
+	transport = &timeoutTransport{
+		timeout: durationOrDefault(cfg.API.RequestTimeout, defaultAPIRequestTimeout),
+		next:    transport,
+	}
+	transport = &breakerTransport{
+		breaker: &circuitBreaker{
+			name:     org.Name,
+			failures: intOrDefault(cfg.API.Breaker.Failures, defaultBreakerFailures),
+			cooldown: durationOrDefault(cfg.API.Breaker.Cooldown, defaultBreakerCooldown),
+		},
+		next: transport,
+	}
+
+	client.HTTPClient = &http.Client{
+		Transport: transport,
+	}
+
+	return client, nil
+}
+
+// timeoutTransport gives each request (along with its retries) until timeout to
+// finish. It sits below breakerTransport so requests timing out count as failures
+// while callers giving up don't, which an http.Client Timeout can't tell apart.
+type timeoutTransport struct {
+	timeout time.Duration
+	next    http.RoundTripper
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody cancels the context of its request once the body is closed, so
+// the timeout also covers reading it.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// load reads the CA bundle and client certificate of cfg.
+func (cfg tlsConfig) load() (*tls.Config, error) {
+	conf := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		bs, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(bs) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// newTransport returns an http.Transport like http.DefaultTransport using conf.
+func newTransport(conf *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       conf,
+	}
+}
+
+// basePathTransport keeps the path of base (i.e. /api/ on Travis CI Enterprise)
+// on every request. go-travis services ask for absolute paths like /repo/{slug}/crons
+// which would otherwise drop it.
+type basePathTransport struct {
+	base *url.URL
+	next http.RoundTripper
+}
+
+func (t *basePathTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.URL.Path, t.base.Path) {
+		return t.next.RoundTrip(req)
+	}
+	r := new(http.Request)
+	*r = *req
+	u := *req.URL
+	u.Path = strings.TrimSuffix(t.base.Path, "/") + req.URL.Path
+	if u.RawPath != "" {
+		u.RawPath = strings.TrimSuffix(t.base.Path, "/") + req.URL.RawPath
+	}
+	r.URL = &u
+	return t.next.RoundTrip(r)
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/shuheiktgw/go-travis"
+)
+
+func TestBasePathTransport(t *testing.T) {
+	cases := []struct {
+		name string
+		path string // as go-travis services pass it to NewRequest
+
+		wantPath     string
+		wantRawPath  string
+		wantQuery    string
+		wantEndpoint string
+	}{
+		{
+			name:         "absolute",
+			path:         "/builds",
+			wantPath:     "/api/builds",
+			wantEndpoint: "builds",
+		},
+		{
+			name:         "absolute with an escaped slug",
+			path:         "/repo/moov-io%2Fach/crons",
+			wantPath:     "/api/repo/moov-io/ach/crons",
+			wantRawPath:  "/api/repo/moov-io%2Fach/crons",
+			wantEndpoint: "repo/:slug/crons",
+		},
+		{
+			name:         "absolute with a query",
+			path:         "/repo/moov-io%2Fach/builds?limit=10&offset=20",
+			wantPath:     "/api/repo/moov-io/ach/builds",
+			wantRawPath:  "/api/repo/moov-io%2Fach/builds",
+			wantQuery:    "limit=10&offset=20",
+			wantEndpoint: "repo/:slug/builds",
+		},
+		{
+			name:         "relative",
+			path:         "builds",
+			wantPath:     "/api/builds",
+			wantEndpoint: "builds",
+		},
+		{
+			name:         "relative with an escaped slug",
+			path:         "repo/moov-io%2Fach/crons",
+			wantPath:     "/api/repo/moov-io/ach/crons",
+			wantRawPath:  "/api/repo/moov-io%2Fach/crons",
+			wantEndpoint: "repo/:slug/crons",
+		},
+		{
+			name:         "already under base",
+			path:         "/api/owner/moov-io/repos",
+			wantPath:     "/api/owner/moov-io/repos",
+			wantEndpoint: "owner/:login/repos",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := travis.NewClient("https://travis.example.com/api/", "")
+			req, err := client.NewRequest(http.MethodGet, tc.path, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			original := req.URL.String()
+
+			var got *http.Request
+			transport := &basePathTransport{
+				base: client.BaseURL,
+				next: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+					got = r
+					return &http.Response{StatusCode: http.StatusOK}, nil
+				}),
+			}
+			if _, err := transport.RoundTrip(req); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.URL.Path != tc.wantPath {
+				t.Errorf("got path %s, expected %s", got.URL.Path, tc.wantPath)
+			}
+			if got.URL.RawPath != tc.wantRawPath {
+				t.Errorf("got raw path %s, expected %s", got.URL.RawPath, tc.wantRawPath)
+			}
+			if got.URL.RawQuery != tc.wantQuery {
+				t.Errorf("got query %s, expected %s", got.URL.RawQuery, tc.wantQuery)
+			}
+			if got.URL.Host != "travis.example.com" {
+				t.Errorf("got host %s", got.URL.Host)
+			}
+			// instrumentTransport sits above us, so it names the request before its path is fixed
+			base := client.BaseURL.EscapedPath()
+			if name := endpointName(base, req); name != tc.wantEndpoint {
+				t.Errorf("got endpoint %s, expected %s", name, tc.wantEndpoint)
+			}
+			if name := endpointName(base, got); name != tc.wantEndpoint {
+				t.Errorf("got endpoint %s after fixing the path, expected %s", name, tc.wantEndpoint)
+			}
+			if req.URL.String() != original {
+				t.Errorf("request was changed from %s to %s", original, req.URL)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	snapshotAgeDesc = prometheus.NewDesc(
+		"travisci_exporter_snapshot_age_seconds",
+		"Seconds since each check of an organization last finished successfully",
+		[]string{"org", "check"}, nil,
+	)
+	trackedSeriesDesc = prometheus.NewDesc(
+		"travisci_exporter_tracked_series",
+		"Count of series each organization's checker holds",
+		[]string{"org"}, nil,
+	)
+)
+
+// collector is a prometheus.Collector serving the latest snapshot of each
+// checker. Scrapes never wait on the TravisCI API, they only read snapshots.
+type collector struct {
+	mu       sync.Mutex
+	checkers []*checker
+}
+
+func (c *collector) add(check *checker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checkers = append(c.checkers, check)
+}
+
+// remove stops serving the snapshots of check.
+func (c *collector) remove(check *checker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.checkers {
+		if c.checkers[i] == check {
+			c.checkers = append(c.checkers[:i], c.checkers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Describe sends nothing, which makes collector unchecked as its label values
+// depend on what we find in TravisCI.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	checkers := append([]*checker(nil), c.checkers...)
+	c.mu.Unlock()
+
+	// Each organization reports on its own builds, but relabeling or dropped
+	// labels (i.e. org) can leave series from different checkers with the same
+	// labels, so they're merged. Counters, histograms and gauges which count
+	// things are summed, the first value of other gauges wins.
+	merged := make(map[*family]map[string]*series)
+	now := time.Now()
+	for _, check := range checkers {
+		snap := check.latest()
+		if snap == nil {
+			continue // no check has finished yet
+		}
+		for name, t := range snap.updated {
+			ch <- prometheus.MustNewConstMetric(snapshotAgeDesc, prometheus.GaugeValue, now.Sub(t).Seconds(), check.name, name)
+		}
+		var tracked int
+		for f, ss := range snap.series {
+			tracked += len(ss)
+			if merged[f] == nil {
+				merged[f] = make(map[string]*series)
+			}
+			for k, s := range ss {
+				merged[f][k] = mergeSeries(f, merged[f][k], s)
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(trackedSeriesDesc, prometheus.GaugeValue, float64(tracked), check.name)
+	}
+
+	for f, ss := range merged {
+		for _, s := range ss {
+			ch <- constMetric(f, s)
+		}
+	}
+}
+
+// mergeSeries combines two series of f without modifying either one.
+func mergeSeries(f *family, a, b *series) *series {
+	if a == nil {
+		return b
+	}
+	if f.kind == gaugeKind && !f.sum {
+		return a
+	}
+	out := &series{
+		labels: a.labels,
+		value:  a.value + b.value,
+		count:  a.count + b.count,
+		sum:    a.sum + b.sum,
+	}
+	if a.buckets != nil {
+		out.buckets = make([]uint64, len(a.buckets))
+		for i := range a.buckets {
+			out.buckets[i] = a.buckets[i] + b.buckets[i]
+		}
+	}
+	return out
+}
+
+func constMetric(f *family, s *series) prometheus.Metric {
+	switch f.kind {
+	case counterKind:
+		return prometheus.MustNewConstMetric(f.desc, prometheus.CounterValue, s.value, s.labels...)
+	case histogramKind:
+		buckets := make(map[float64]uint64, len(f.buckets))
+		for i := range f.buckets {
+			buckets[f.buckets[i]] = s.buckets[i]
+		}
+		return prometheus.MustNewConstHistogram(f.desc, s.count, s.sum, buckets, s.labels...)
+	}
+	return prometheus.MustNewConstMetric(f.desc, prometheus.GaugeValue, s.value, s.labels...)
+}
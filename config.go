@@ -4,13 +4,384 @@
 
 package main
 
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
 type config struct {
 	Organizations []organization `yaml:"organizations"`
+
+	API        api         `yaml:"api,omitempty"`
+	Builds     builds      `yaml:"builds,omitempty"`
+	Histograms histograms  `yaml:"histograms,omitempty"`
+	Crons      crons       `yaml:"crons,omitempty"`
+	Settings   settings    `yaml:"settings,omitempty"`
+	Labels     labelConfig `yaml:"labels,omitempty"`
 }
 
 type organization struct {
-	Name  string `yaml:"name"`
-	Token string `yaml:"token"`
+	Name string `yaml:"name"`
+
+	// Token, TokenFile or TokenCommand supplies the TravisCI token. A token
+	// file is read again when it changes and a token command is run again
+	// once TravisCI rejects its token.
+	Token        string   `yaml:"token,omitempty"`
+	TokenFile    string   `yaml:"token_file,omitempty"`
+	TokenCommand []string `yaml:"token_command,omitempty"`
 
 	UseOrg bool `yaml:"org,omitempty"`
+
+	// APIURL is the API of a Travis CI Enterprise install, e.g.
+	// https://travis.example.com/api/. It can't be used along with org.
+	APIURL string    `yaml:"api_url,omitempty"`
+	TLS    tlsConfig `yaml:"tls,omitempty"`
+
+	Repos repoFilter `yaml:"repos,omitempty"`
+
+	// matcher is Repos once checked by validate
+	matcher *repoMatcher
+}
+
+// tlsConfig controls how we connect to an organization's API.
+type tlsConfig struct {
+	// CAFile is a PEM bundle trusted along with the system's certificates.
+	CAFile string `yaml:"ca_file,omitempty"`
+
+	// CertFile and KeyFile are a PEM client certificate and key.
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// repoFilter limits which of an organization's repositories we report on.
+type repoFilter struct {
+	// Include and Exclude are globs (moov-io/*) or, wrapped in slashes,
+	// regexes (/^moov-io/(ach|wire)$/) matched against each repository slug.
+	// Without any includes every repository is included.
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+
+	SkipForks    bool `yaml:"skip_forks,omitempty"`
+	SkipPrivate  bool `yaml:"skip_private,omitempty"`
+	SkipInactive bool `yaml:"skip_inactive,omitempty"`
+}
+
+// api controls how hard we lean on the TravisCI API.
+type api struct {
+	// Concurrency is the number of build and job lookups made at once.
+	Concurrency int `yaml:"concurrency,omitempty"`
+
+	// RequestsPerSecond and Burst size the token bucket shared by every
+	// organization using the same token. Zero disables rate limiting.
+	RequestsPerSecond float64 `yaml:"requests_per_second,omitempty"`
+	Burst             int     `yaml:"burst,omitempty"`
+
+	// PollTimeout is the deadline for each organization's check of builds,
+	// it defaults to -interval.
+	PollTimeout time.Duration `yaml:"poll_timeout,omitempty"`
+
+	// RequestTimeout is the deadline for each call to the TravisCI API,
+	// including time spent waiting on our rate limit and retries.
+	RequestTimeout time.Duration `yaml:"request_timeout,omitempty"`
+
+	Retry   retry   `yaml:"retry,omitempty"`
+	Breaker breaker `yaml:"breaker,omitempty"`
+}
+
+// retry controls how throttled (429) or failed (5xx) API calls are retried.
+type retry struct {
+	// Attempts is the most times a request is sent, 1 disables retries.
+	Attempts int `yaml:"attempts,omitempty"`
+
+	// MinBackoff and MaxBackoff bound the exponential backoff between attempts.
+	// A Retry-After or X-RateLimit-Reset header from TravisCI takes precedence,
+	// up to MaxBackoff.
+	MinBackoff time.Duration `yaml:"min_backoff,omitempty"`
+	MaxBackoff time.Duration `yaml:"max_backoff,omitempty"`
+}
+
+// breaker controls each organization's circuit breaker.
+type breaker struct {
+	// Failures is how many failed requests in a row open the breaker.
+	Failures int `yaml:"failures,omitempty"`
+
+	// Cooldown is how long the breaker stays open before trying again.
+	Cooldown time.Duration `yaml:"cooldown,omitempty"`
+}
+
+// builds controls how far back each check pages through TravisCI builds.
+type builds struct {
+	// PageSize is the number of builds requested per page.
+	PageSize int `yaml:"page_size,omitempty"`
+
+	// MaxPages caps how many pages are read on each check.
+	MaxPages int `yaml:"max_pages,omitempty"`
+
+	// Lookback stops paging once builds started longer ago than this.
+	Lookback time.Duration `yaml:"lookback,omitempty"`
+
+	// Retention is how long we remember builds, jobs, stages and requests.
+	// Counter and histogram series which haven't changed within it are
+	// dropped, and anything older isn't recorded.
+	Retention time.Duration `yaml:"retention,omitempty"`
+}
+
+// histograms holds the bucket layout (in seconds) for each histogram we export.
+// Empty slices fall back to our defaults.
+type histograms struct {
+	JobDurationBuckets   []float64 `yaml:"job_duration_buckets,omitempty"`
+	BuildDurationBuckets []float64 `yaml:"build_duration_buckets,omitempty"`
+	QueueWaitBuckets     []float64 `yaml:"queue_wait_buckets,omitempty"`
+	StageDurationBuckets []float64 `yaml:"stage_duration_buckets,omitempty"`
+}
+
+type crons struct {
+	// GracePeriod is how late a cron can be before we consider its run missed.
+	GracePeriod time.Duration `yaml:"grace_period,omitempty"`
+}
+
+type settings struct {
+	// Desired maps repository setting names (e.g. auto_cancel_pushes) to the
+	// value every repository should have, either a boolean or number.
+	Desired map[string]interface{} `yaml:"desired,omitempty"`
+}
+
+// labelConfig chooses the labels each metric is exported with.
+type labelConfig struct {
+	// Families maps a metric name to the subset of its labels to export.
+	// Series which only differed by a dropped label are added up, which is
+	// only allowed for counters, histograms and gauges counting things (i.e.
+	// travisci_jobs_waiting or travisci_caches).
+	Families map[string][]string `yaml:"families,omitempty"`
+
+	// Relabel rules run, in order, on every series before it's recorded.
+	Relabel []relabelRule `yaml:"relabel,omitempty"`
+}
+
+// relabelRule mirrors a Prometheus relabel_config. Labels starting with __
+// can be used as scratch space between rules.
+type relabelRule struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    string   `yaml:"separator,omitempty"`
+	Regex        string   `yaml:"regex,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+	Modulus      uint64   `yaml:"modulus,omitempty"`
+
+	// Action is one of replace (the default), keep, drop or hashmod.
+	Action string `yaml:"action,omitempty"`
+
+	// Metrics limits the rule to these metrics, it runs on every metric by default.
+	Metrics []string `yaml:"metrics,omitempty"`
+}
+
+var (
+	defaultAPIConcurrency    = 4
+	defaultAPIRequestTimeout = 30 * time.Second
+
+	defaultRetryAttempts   = 3
+	defaultRetryMinBackoff = time.Second
+	defaultRetryMaxBackoff = 30 * time.Second
+
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = time.Minute
+
+	defaultBuildsPageSize  = 100
+	defaultBuildsMaxPages  = 10
+	defaultBuildsLookback  = 24 * time.Hour
+	defaultBuildsRetention = 7 * 24 * time.Hour
+
+	// defaultDurationBuckets covers 30s through 2h, which is where most CI jobs land
+	defaultDurationBuckets = []float64{30, 60, 120, 300, 600, 900, 1200, 1800, 2700, 3600, 7200}
+
+	// defaultQueueWaitBuckets covers 5s through 1h, anything longer is a stuck queue
+	defaultQueueWaitBuckets = []float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+)
+
+// readConfig reads our config from path, replacing ${VAR} in its strings with
+// the value of each environment variable.
+func readConfig(path string) (*config, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading %s: %v", path, err)
+	}
+	var cfg config
+	if err := yaml.Unmarshal(bs, &cfg); err != nil {
+		return nil, fmt.Errorf("problem unmarshaling %s: %v", path, err)
+	}
+	if err := expandEnv(&cfg); err != nil {
+		return nil, fmt.Errorf("problem reading %s: %v", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return &cfg, nil
+}
+
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces each ${VAR} in the string fields of cfg with the environment
+// variable VAR, which must be set. It runs once cfg is parsed, so comments are
+// ignored and values can't change the structure of our config. Other uses of $
+// (i.e. relabel replacements) are left alone.
+func expandEnv(cfg *config) error {
+	missing := make(map[string]bool)
+	expandStrings(reflect.ValueOf(cfg).Elem(), func(s string) string {
+		return envVarPattern.ReplaceAllStringFunc(s, func(match string) string {
+			name := envVarPattern.FindStringSubmatch(match)[1]
+			value, exists := os.LookupEnv(name)
+			if !exists {
+				missing[name] = true
+			}
+			return value
+		})
+	})
+	if len(missing) > 0 {
+		var names []string
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("environment variables %s aren't set", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// expandStrings calls expand on every string v holds, through its exported
+// fields, slices, maps and interfaces, replacing each with the result. v must
+// be settable.
+func expandStrings(v reflect.Value, expand func(string) string) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(expand(v.String()))
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" { // exported
+				expandStrings(v.Field(i), expand)
+			}
+		}
+
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandStrings(v.Index(i), expand)
+		}
+
+	case reflect.Map:
+		// Map values aren't settable, so each one is expanded in a copy
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			expandStrings(elem, expand)
+			v.SetMapIndex(key, elem)
+		}
+
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if v.Kind() == reflect.Ptr {
+			expandStrings(v.Elem(), expand)
+			return
+		}
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		expandStrings(elem, expand)
+		v.Set(elem)
+	}
+}
+
+func bucketsOrDefault(buckets []float64, def []float64) []float64 {
+	if len(buckets) == 0 {
+		return def
+	}
+	return buckets
+}
+
+func intOrDefault(v int, def int) int {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+func durationOrDefault(v time.Duration, def time.Duration) time.Duration {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+func (cfg *config) validate() error {
+	names := make(map[string]bool)
+	for i := range cfg.Organizations {
+		org := cfg.Organizations[i]
+		if names[org.Name] {
+			return fmt.Errorf("organizations.%s: listed more than once", org.Name)
+		}
+		names[org.Name] = true
+
+		sources := 0
+		for _, set := range []bool{org.Token != "", org.TokenFile != "", len(org.TokenCommand) > 0} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("organizations.%s: exactly one of token, token_file or token_command is required", org.Name)
+		}
+		if org.APIURL != "" {
+			if org.UseOrg {
+				return fmt.Errorf("organizations.%s: api_url and org can't both be set", org.Name)
+			}
+			if u, err := url.Parse(org.APIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("organizations.%s: api_url %q isn't an http(s) URL", org.Name, org.APIURL)
+			}
+		}
+		if (org.TLS.CertFile == "") != (org.TLS.KeyFile == "") {
+			return fmt.Errorf("organizations.%s.tls: cert_file and key_file must be set together", org.Name)
+		}
+
+		m, err := newRepoMatcher(cfg.Organizations[i].Repos)
+		if err != nil {
+			return fmt.Errorf("organizations.%s: %v", cfg.Organizations[i].Name, err)
+		}
+		cfg.Organizations[i].matcher = m
+	}
+	if cfg.API.Concurrency < 0 || cfg.API.RequestsPerSecond < 0 || cfg.API.Burst < 0 || cfg.API.PollTimeout < 0 || cfg.API.RequestTimeout < 0 {
+		return fmt.Errorf("api: concurrency, requests_per_second, burst, poll_timeout and request_timeout can't be negative")
+	}
+	if cfg.API.Retry.Attempts < 0 || cfg.API.Retry.MinBackoff < 0 || cfg.API.Retry.MaxBackoff < 0 {
+		return fmt.Errorf("api.retry: attempts, min_backoff and max_backoff can't be negative")
+	}
+	if cfg.API.Breaker.Failures < 0 || cfg.API.Breaker.Cooldown < 0 {
+		return fmt.Errorf("api.breaker: failures and cooldown can't be negative")
+	}
+	if cfg.Builds.PageSize < 0 || cfg.Builds.MaxPages < 0 || cfg.Builds.Lookback < 0 || cfg.Builds.Retention < 0 {
+		return fmt.Errorf("builds: page_size, max_pages, lookback and retention can't be negative")
+	}
+	lookback := durationOrDefault(cfg.Builds.Lookback, defaultBuildsLookback)
+	if retention := durationOrDefault(cfg.Builds.Retention, defaultBuildsRetention); retention < lookback {
+		return fmt.Errorf("builds: retention (%v) can't be shorter than lookback (%v)", retention, lookback)
+	}
+	if err := validateLabels(cfg.Labels); err != nil {
+		return err
+	}
+	for name, v := range cfg.Settings.Desired {
+		if _, ok := settingValue(v); !ok {
+			return fmt.Errorf("settings.desired.%s: %v isn't a boolean or number", name, v)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,119 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("TRAVISCI_EXPORTER_TEST_TOKEN", "secret")
+	os.Setenv("TRAVISCI_EXPORTER_TEST_EMPTY", "")
+	defer os.Unsetenv("TRAVISCI_EXPORTER_TEST_TOKEN")
+	defer os.Unsetenv("TRAVISCI_EXPORTER_TEST_EMPTY")
+
+	cases := []struct {
+		name    string
+		input   config
+		want    config
+		invalid bool
+	}{
+		{
+			name:  "no variables",
+			input: config{Organizations: []organization{{Name: "moov-io", Token: "abc"}}},
+			want:  config{Organizations: []organization{{Name: "moov-io", Token: "abc"}}},
+		},
+		{
+			name:  "variable",
+			input: config{Organizations: []organization{{Name: "moov-io", Token: "${TRAVISCI_EXPORTER_TEST_TOKEN}"}}},
+			want:  config{Organizations: []organization{{Name: "moov-io", Token: "secret"}}},
+		},
+		{
+			name:  "repeated",
+			input: config{Organizations: []organization{{Name: "moov-io", Token: "${TRAVISCI_EXPORTER_TEST_TOKEN}-${TRAVISCI_EXPORTER_TEST_TOKEN}"}}},
+			want:  config{Organizations: []organization{{Name: "moov-io", Token: "secret-secret"}}},
+		},
+		{
+			name:  "set but empty",
+			input: config{Organizations: []organization{{Name: "moov-io", Token: "${TRAVISCI_EXPORTER_TEST_EMPTY}"}}},
+			want:  config{Organizations: []organization{{Name: "moov-io", Token: ""}}},
+		},
+		{
+			name: "slices and maps",
+			input: config{
+				Organizations: []organization{{Name: "moov-io", TokenCommand: []string{"echo", "${TRAVISCI_EXPORTER_TEST_TOKEN}"}}},
+				Labels:        labelConfig{Families: map[string][]string{"${TRAVISCI_EXPORTER_TEST_TOKEN}": {"${TRAVISCI_EXPORTER_TEST_TOKEN}"}}},
+				Settings:      settings{Desired: map[string]interface{}{"a": "${TRAVISCI_EXPORTER_TEST_TOKEN}", "b": true}},
+			},
+			want: config{
+				Organizations: []organization{{Name: "moov-io", TokenCommand: []string{"echo", "secret"}}},
+				Labels:        labelConfig{Families: map[string][]string{"${TRAVISCI_EXPORTER_TEST_TOKEN}": {"secret"}}},
+				Settings:      settings{Desired: map[string]interface{}{"a": "secret", "b": true}},
+			},
+		},
+		{
+			name:  "relabel replacements left alone",
+			input: config{Labels: labelConfig{Relabel: []relabelRule{{Replacement: "$1 ${1} $TRAVISCI_EXPORTER_TEST_TOKEN"}}}},
+			want:  config{Labels: labelConfig{Relabel: []relabelRule{{Replacement: "$1 ${1} $TRAVISCI_EXPORTER_TEST_TOKEN"}}}},
+		},
+		{
+			name:    "unset",
+			input:   config{Organizations: []organization{{Name: "moov-io", Token: "${TRAVISCI_EXPORTER_TEST_UNSET}"}}},
+			invalid: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := expandEnv(&tc.input)
+			if tc.invalid {
+				if err == nil {
+					t.Errorf("expected error, got %#v", tc.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.input, tc.want) {
+				t.Errorf("got %#v, expected %#v", tc.input, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadConfig__env(t *testing.T) {
+	os.Setenv("TRAVISCI_EXPORTER_TEST_TOKEN", "abc # not a comment\nname: other")
+	defer os.Unsetenv("TRAVISCI_EXPORTER_TEST_TOKEN")
+
+	dir, err := ioutil.TempDir("", "travisci_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	contents := `# token: ${TRAVISCI_EXPORTER_TEST_UNSET} is only in a comment
+organizations:
+  - name: moov-io
+    token: ${TRAVISCI_EXPORTER_TEST_TOKEN}
+`
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Organizations) != 1 || cfg.Organizations[0].Name != "moov-io" {
+		t.Fatalf("unexpected organizations: %#v", cfg.Organizations)
+	}
+	if token := cfg.Organizations[0].Token; token != "abc # not a comment\nname: other" {
+		t.Errorf("got token %q", token)
+	}
+}
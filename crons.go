@@ -0,0 +1,109 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/shuheiktgw/go-travis"
+)
+
+var (
+	defaultCronGracePeriod = time.Hour
+
+	// cronIntervals maps TravisCI's cron intervals to their length, months
+	// are rounded up so we don't flag a cron as missed in longer months.
+	cronIntervals = map[string]time.Duration{
+		travis.CronIntervalDaily:   24 * time.Hour,
+		travis.CronIntervalWeekly:  7 * 24 * time.Hour,
+		travis.CronIntervalMonthly: 31 * 24 * time.Hour,
+	}
+)
+
+// checkCrons replaces the travisci_cron_* gauges with the crons across our repositories.
+// Repositories we couldn't read keep their last crons. It returns false if every
+// repository failed.
+func (c *checker) checkCrons(ctx context.Context) bool {
+	grace := c.config().Crons.GracePeriod
+	if grace == 0 {
+		grace = defaultCronGracePeriod
+	}
+
+	slugs := c.repoSlugs()
+	read := 0
+	for _, slug := range slugs {
+		if ctx.Err() != nil {
+			break // out of time, skip the rest
+		}
+		crons, resp, err := c.client.Crons.ListByRepoSlug(ctx, slug, &travis.CronsOption{
+			Limit: 100,
+		})
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			log.Printf("ERROR: %s crons for %s from travis-ci api: %v", c.name, slug, err)
+			continue // keep what we knew about this repo's crons
+		}
+		read++
+
+		var next, last, interval, active, missed []gaugeSample
+		for i := range crons {
+			cron := crons[i]
+			labels := []string{slug, cron.Branch.Name}
+
+			if t, err := time.Parse(timestampFormat, cron.NextRun); err == nil {
+				next = append(next, gaugeSample{labels: labels, value: float64(t.Unix())})
+			}
+			if t, err := time.Parse(timestampFormat, cron.LastRun); err == nil {
+				last = append(last, gaugeSample{labels: labels, value: float64(t.Unix())})
+			}
+			if d, exists := cronIntervals[cron.Interval]; exists {
+				interval = append(interval, gaugeSample{labels: labels, value: d.Seconds()})
+			}
+			if cron.Active {
+				active = append(active, gaugeSample{labels: labels, value: 1})
+			} else {
+				active = append(active, gaugeSample{labels: labels, value: 0})
+			}
+			if cronMissed(&cron, grace) {
+				missed = append(missed, gaugeSample{labels: labels, value: 1})
+			} else {
+				missed = append(missed, gaugeSample{labels: labels, value: 0})
+			}
+		}
+		c.cronGauges.set(cronNextRun, slug, next)
+		c.cronGauges.set(cronLastRun, slug, last)
+		c.cronGauges.set(cronInterval, slug, interval)
+		c.cronGauges.set(cronActive, slug, active)
+		c.cronGauges.set(cronMissedRun, slug, missed)
+	}
+	c.cronGauges.retain(slugs)
+
+	for _, f := range []*family{cronNextRun, cronLastRun, cronInterval, cronActive, cronMissedRun} {
+		c.metrics.replace(f, c.cronGauges.samples(f))
+	}
+	return read > 0 || len(slugs) == 0
+}
+
+// cronMissed returns true if an active cron's last run (or creation, if it's
+// never run) is more than one interval plus grace in the past.
+func cronMissed(cron *travis.Cron, grace time.Duration) bool {
+	interval, exists := cronIntervals[cron.Interval]
+	if !cron.Active || !exists {
+		return false
+	}
+	ran := cron.LastRun
+	if ran == "" {
+		ran = cron.CreatedAt
+	}
+	t, err := time.Parse(timestampFormat, ran)
+	if err != nil {
+		return false
+	}
+	return time.Since(t) > interval+grace
+}
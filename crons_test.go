@@ -0,0 +1,85 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shuheiktgw/go-travis"
+)
+
+func TestCronMissed(t *testing.T) {
+	ago := func(d time.Duration) string {
+		return time.Now().Add(-d).UTC().Format(timestampFormat)
+	}
+	grace := time.Hour
+
+	cases := []struct {
+		name string
+		cron travis.Cron
+		want bool
+	}{
+		{
+			name: "daily ran recently",
+			cron: travis.Cron{Active: true, Interval: travis.CronIntervalDaily, LastRun: ago(2 * time.Hour)},
+		},
+		{
+			name: "daily late within grace",
+			cron: travis.Cron{Active: true, Interval: travis.CronIntervalDaily, LastRun: ago(24*time.Hour + 30*time.Minute)},
+		},
+		{
+			name: "daily missed",
+			cron: travis.Cron{Active: true, Interval: travis.CronIntervalDaily, LastRun: ago(26 * time.Hour)},
+			want: true,
+		},
+		{
+			name: "weekly ran recently",
+			cron: travis.Cron{Active: true, Interval: travis.CronIntervalWeekly, LastRun: ago(6 * 24 * time.Hour)},
+		},
+		{
+			name: "weekly missed",
+			cron: travis.Cron{Active: true, Interval: travis.CronIntervalWeekly, LastRun: ago(8 * 24 * time.Hour)},
+			want: true,
+		},
+		{
+			name: "monthly in a long month",
+			cron: travis.Cron{Active: true, Interval: travis.CronIntervalMonthly, LastRun: ago(30*24*time.Hour + 12*time.Hour)},
+		},
+		{
+			name: "monthly missed",
+			cron: travis.Cron{Active: true, Interval: travis.CronIntervalMonthly, LastRun: ago(33 * 24 * time.Hour)},
+			want: true,
+		},
+		{
+			name: "never ran since created",
+			cron: travis.Cron{Active: true, Interval: travis.CronIntervalDaily, CreatedAt: ago(2 * time.Hour)},
+		},
+		{
+			name: "never ran and missed",
+			cron: travis.Cron{Active: true, Interval: travis.CronIntervalDaily, CreatedAt: ago(48 * time.Hour)},
+			want: true,
+		},
+		{
+			name: "inactive",
+			cron: travis.Cron{Interval: travis.CronIntervalDaily, LastRun: ago(48 * time.Hour)},
+		},
+		{
+			name: "unknown interval",
+			cron: travis.Cron{Active: true, Interval: "hourly", LastRun: ago(48 * time.Hour)},
+		},
+		{
+			name: "unparsable timestamp",
+			cron: travis.Cron{Active: true, Interval: travis.CronIntervalDaily, LastRun: "yesterday"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := cronMissed(&tc.cron, grace); got != tc.want {
+				t.Errorf("got %v, expected %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Metrics about the exporter itself
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "travisci_exporter_build_info",
+		Help: "Always 1, labeled by the version of travisci_exporter running",
+	}, []string{"version"})
+
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "travisci_exporter_api_requests_total",
+		Help: "Count of requests made to the TravisCI API",
+	}, []string{"org", "endpoint", "code"})
+
+	apiErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "travisci_exporter_api_errors_total",
+		Help: "Count of requests to the TravisCI API which failed or returned a 4xx or 5xx",
+	}, []string{"org", "endpoint", "code"})
+
+	apiDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "travisci_exporter_api_request_duration_seconds",
+		Help:    "Latency in seconds of requests to the TravisCI API",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"org", "endpoint"})
+
+	pollDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "travisci_exporter_poll_duration_seconds",
+		Help:    "Seconds each check of an organization took",
+		Buckets: []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"org", "check"})
+
+	lastSuccessfulPoll = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "travisci_exporter_last_successful_poll_timestamp_seconds",
+		Help: "Unix timestamp of when each check of an organization last finished successfully",
+	}, []string{"org", "check"})
+
+	jobsSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "travisci_exporter_jobs_skipped_total",
+		Help: "Count of finished TravisCI jobs whose duration couldn't be parsed",
+	}, []string{"org"})
+
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "travisci_exporter_config_reloads_total",
+		Help: "Count of config reloads by result (success or failure)",
+	}, []string{"result"})
+
+	configLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "travisci_exporter_config_last_reload_successful",
+		Help: "1 if the last config reload succeeded, 0 otherwise",
+	})
+
+	configLastReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "travisci_exporter_config_last_reload_success_timestamp_seconds",
+		Help: "Unix timestamp of the last successful config load",
+	})
+
+	exporterMetrics = []prometheus.Collector{
+		buildInfo, apiRequests, apiErrors, apiDuration, pollDuration, lastSuccessfulPoll, jobsSkipped,
+		configReloads, configLastReloadSuccessful, configLastReloadSuccess,
+	}
+)
+
+// instrumentTransport records the count, outcome and latency of each request
+// sent to the TravisCI API for org.
+type instrumentTransport struct {
+	org string
+
+	// base is the escaped path of the API (i.e. /api/ on Travis CI Enterprise)
+	// which is left out of our endpoint label.
+	base string
+
+	next http.RoundTripper
+}
+
+func (t *instrumentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := endpointName(t.base, req)
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	apiDuration.WithLabelValues(t.org, endpoint).Observe(time.Since(start).Seconds())
+
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	apiRequests.WithLabelValues(t.org, endpoint, code).Inc()
+	if err != nil || resp.StatusCode >= 400 {
+		apiErrors.WithLabelValues(t.org, endpoint, code).Inc()
+	}
+	return resp, err
+}
+
+// endpointName returns the path of req under base with repository slugs, owner
+// logins and ids replaced by placeholders, e.g. repo/:slug/caches or build/:id.
+// Requests are named the same whether or not they already include base.
+func endpointName(base string, req *http.Request) string {
+	path := req.URL.EscapedPath()
+	if base = strings.TrimSuffix(base, "/"); path == base || strings.HasPrefix(path, base+"/") {
+		path = strings.TrimPrefix(path, base)
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := range parts {
+		if i > 0 && parts[i-1] == "repo" {
+			parts[i] = ":slug"
+			continue
+		}
+		if i > 0 && parts[i-1] == "owner" {
+			parts[i] = ":login"
+			continue
+		}
+		if _, err := strconv.ParseUint(parts[i], 10, 64); err == nil {
+			parts[i] = ":id"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// succeeded records that check of c has just finished successfully.
+func (c *checker) succeeded(check string) {
+	c.metrics.touch(check)
+	lastSuccessfulPoll.WithLabelValues(c.name, check).SetToCurrentTime()
+}
+
+// observePoll records how long check of c took since start.
+func (c *checker) observePoll(check string, start time.Time) {
+	pollDuration.WithLabelValues(c.name, check).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,40 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestEndpointName(t *testing.T) {
+	cases := []struct {
+		base string
+		url  string
+		want string
+	}{
+		{"/", "https://api.travis-ci.com/builds", "builds"},
+		{"/", "https://api.travis-ci.com/repo/moov-io%2Fach/crons", "repo/:slug/crons"},
+		{"/", "https://api.travis-ci.com/owner/moov-io/repos?limit=100", "owner/:login/repos"},
+		{"/", "https://api.travis-ci.com/build/123", "build/:id"},
+		{"/", "https://api.travis-ci.com/job/456/log", "job/:id/log"},
+		{"/api/", "https://travis.example.com/api/builds", "builds"},
+		{"/api/", "https://travis.example.com/api/repo/moov-io%2Fach/crons", "repo/:slug/crons"},
+		{"/api/", "https://travis.example.com/api/repo/moov-io%2Fach/env_var/42", "repo/:slug/env_var/:id"},
+		{"/api/", "https://travis.example.com/api/owner/moov-io/repos", "owner/:login/repos"},
+		{"/api/", "https://travis.example.com/api/build/123", "build/:id"},
+		{"/api/", "https://travis.example.com/repo/moov-io%2Fach/crons", "repo/:slug/crons"}, // before basePathTransport
+		{"/api/", "https://travis.example.com/apiary/builds", "apiary/builds"},
+	}
+	for _, tc := range cases {
+		req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := endpointName(tc.base, req); got != tc.want {
+			t.Errorf("%s under %s: got %s, expected %s", tc.url, tc.base, got, tc.want)
+		}
+	}
+}
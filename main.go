@@ -8,21 +8,24 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/shuheiktgw/go-travis"
-	"gopkg.in/yaml.v2"
 )
 
 const version = "0.2.1-dev"
 
 var (
-	defaultInterval, _ = time.ParseDuration("1m")
+	defaultInterval, _       = time.ParseDuration("1m")
+	defaultActiveInterval, _ = time.ParseDuration("15s")
+	defaultCachesInterval, _ = time.ParseDuration("1h")
+	defaultReposInterval, _  = time.ParseDuration("10m")
 
 	timestampFormat = "2006-01-02T15:04:05Z"
 
@@ -30,18 +33,15 @@ var (
 	flagAddress    = flag.String("address", "0.0.0.0:9099", "HTTP listen address")
 	flagConfigFile = flag.String("config.file", "", "Path to file with TravisCI token (in TOML)")
 	flagInterval   = flag.Duration("interval", defaultInterval, "Interval to check domains at")
-	flagVersion    = flag.Bool("version", false, "Print the rdap_exporter version")
 
-	// Prometheus metrics
-	jobDurations = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "travisci_job_duration_seconds",
-		Help: "Duration in seconds of each TravisCI job",
-	}, []string{"id", "slug"})
-)
+	flagActiveInterval = flag.Duration("active.interval", defaultActiveInterval, "Interval to check currently running builds at")
+	flagCachesInterval = flag.Duration("caches.interval", defaultCachesInterval, "Interval to check build caches at")
+	flagReposInterval  = flag.Duration("repos.interval", defaultReposInterval, "Interval to check repository crons and settings at")
+	flagVersion        = flag.Bool("version", false, "Print the rdap_exporter version")
 
-func init() {
-	prometheus.MustRegister(jobDurations)
-}
+	flagConfigWatchInterval = flag.Duration("config.watch-interval", 0, "Interval to check -config.file for changes and reload it at, 0 disables watching")
+	flagShutdownTimeout     = flag.Duration("shutdown.timeout", 30*time.Second, "How long to wait for scrapes and checks to finish when shutting down")
+)
 
 func main() {
 	flag.Parse()
@@ -52,101 +52,104 @@ func main() {
 		return
 	}
 
+	// Keep TravisCI tokens out of our logs
+	log.SetOutput(redactWriter{w: os.Stderr})
 	log.Printf("Starting travisci_exporter:%s", version)
 
 	// Read our config file
-	var config *config
 	if *flagConfigFile == "" {
 		log.Fatalf("-config.file is empty")
-	} else {
-		bs, err := ioutil.ReadFile(*flagConfigFile)
-		if err != nil {
-			log.Fatalf("problem reading %s: %v", *flagConfigFile, err)
-		}
-		if err := yaml.Unmarshal(bs, &config); err != nil {
-			log.Fatalf("problem unmarshaling %s: %v", *flagConfigFile, err)
-		}
+	}
+	config, err := readConfig(*flagConfigFile)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	for i := range config.Organizations {
-		org := config.Organizations[i]
+	// Cancel everything on SIGINT or SIGTERM
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-		var client *travis.Client
-		if org.UseOrg {
-			client = travis.NewClient(travis.ApiOrgUrl, org.Token)
-		} else {
-			client = travis.NewClient(travis.ApiComUrl, org.Token)
-		}
-		check := &checker{
-			name:     org.Name,
-			client:   client,
-			interval: *flagInterval,
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Printf("received %v, shutting down", sig)
+		cancel()
+	}()
+
+	if err := setupLabels(config.Labels); err != nil {
+		log.Fatalf("ERROR setting up labels: %v", err)
+	}
+	metrics := &collector{}
+	setupMetrics(config.Histograms, metrics)
+
+	exp := &exporter{
+		ctx:            ctx,
+		path:           *flagConfigFile,
+		metrics:        metrics,
+		interval:       *flagInterval,
+		activeInterval: *flagActiveInterval,
+		cachesInterval: *flagCachesInterval,
+		reposInterval:  *flagReposInterval,
+	}
+	if err := exp.apply(config); err != nil {
+		log.Fatalf("ERROR starting checks: %v", err)
+	}
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccess.SetToCurrentTime()
+
+	// Reload our config on SIGHUP, POST /-/reload and (optionally) when it changes
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				exp.reload()
+			case <-ctx.Done():
+				return
+			}
 		}
-		go check.checkAll()
+	}()
+	http.HandleFunc("/-/reload", exp.reloadHandler)
+	if *flagConfigWatchInterval > 0 {
+		go exp.watch(*flagConfigWatchInterval)
 	}
 
 	// Add Prometheus metrics HTTP handler
 	h := promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{})
 	http.Handle("/metrics", h)
 
-	// Block on HTTP server
-	log.Printf("listenting on %s", *flagAddress)
-	if err := http.ListenAndServe(*flagAddress, nil); err != nil {
-		log.Fatalf("ERROR binding to %s: %v", *flagAddress, err)
+	// Start HTTP server
+	serve := &http.Server{
+		Addr: *flagAddress,
 	}
-}
-
-type checker struct {
-	name   string
-	client *travis.Client
+	go func() {
+		log.Printf("listenting on %s", *flagAddress)
+		if err := serve.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("ERROR binding to %s: %v", *flagAddress, err)
+		}
+	}()
 
-	t        *time.Ticker
-	interval time.Duration
-}
+	// Block until we're told to stop, then drain in-flight scrapes and
+	// wait for our checkers to return.
+	<-ctx.Done()
 
-func (c *checker) checkAll() {
-	if c.t == nil {
-		c.t = time.NewTicker(c.interval)
-		c.checkNow() // check domains right away after ticker setup
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), *flagShutdownTimeout)
+	defer shutdownCancel()
+	if err := serve.Shutdown(shutdownCtx); err != nil {
+		log.Printf("ERROR shutting down HTTP server: %v", err)
 	}
-	for range c.t.C {
-		c.checkNow()
-	}
-}
 
-func (c *checker) checkNow() {
-	builds, resp, err := c.client.Builds.List(context.Background(), &travis.BuildsOption{
-		Limit: 100, // TODO(adam): paginate
-	})
-	if resp != nil && resp.Body != nil {
-		resp.Body.Close()
-	}
-	if err != nil {
-		log.Printf("ERROR: %s from travis-ci api: %v", c.name, err)
-	}
-	for i := range builds {
-		for k := range builds[i].Jobs {
-			job, resp, err := c.client.Jobs.Find(context.Background(), builds[i].Jobs[k].Id)
-			if resp != nil && resp.Body != nil {
-				resp.Body.Close()
-			}
-			if err != nil {
-				continue
-			}
-			if job.FinishedAt == "" {
-				continue // can't measure job duration if it's not finished
-			}
-
-			start, err := time.Parse(timestampFormat, job.StartedAt)
-			if err != nil {
-				continue
-			}
-			end, err := time.Parse(timestampFormat, job.FinishedAt)
-			if err != nil {
-				continue
-			}
-
-			jobDurations.WithLabelValues(fmt.Sprintf("%d", builds[i].Jobs[k].Id), builds[i].Repository.Slug).Set(float64(end.Sub(start).Seconds()))
-		}
+	done := make(chan struct{})
+	go func() {
+		exp.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Println("shutdown complete")
+	case <-shutdownCtx.Done():
+		log.Printf("ERROR: checkers didn't stop within %v", *flagShutdownTimeout)
 	}
 }
@@ -0,0 +1,232 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type metricKind int
+
+const (
+	counterKind metricKind = iota
+	gaugeKind
+	histogramKind
+)
+
+// family describes one metric we export. Checkers record values against a
+// family in their metricSet and our collector turns them into const metrics.
+type family struct {
+	name   string
+	help   string
+	kind   metricKind
+	labels []string
+
+	// optional labels follow labels in each series' values, but are only
+	// exported when picked in labels.families as they add cardinality.
+	optional []string
+
+	// sum marks gauges which count things, so series left with the same
+	// labels once some are dropped are added up. Other gauges can't have
+	// labels dropped.
+	sum bool
+
+	// exported and rules are set by setupLabels, they're the labels (out of
+	// labels) we export and the relabel rules run on each series.
+	exported []string
+	rules    []*relabeler
+
+	// buckets and desc are set by setupMetrics once our config is read
+	buckets []float64
+	desc    *prometheus.Desc
+}
+
+var (
+	// Prometheus metrics
+	buildsTotal = &family{
+		name:   "travisci_builds_total",
+		help:   "Count of finished TravisCI builds",
+		kind:   counterKind,
+		labels: []string{"slug", "branch", "event_type", "state"},
+	}
+	jobsTotal = &family{
+		name:     "travisci_jobs_total",
+		help:     "Count of finished TravisCI jobs",
+		kind:     counterKind,
+		labels:   []string{"slug", "state", "allow_failure"},
+		optional: []string{"branch", "queue"},
+	}
+	stagesTotal = &family{
+		name:   "travisci_stages_total",
+		help:   "Count of finished TravisCI build stages",
+		kind:   counterKind,
+		labels: []string{"slug", "stage", "state"},
+	}
+	requestsTotal = &family{
+		name:   "travisci_requests_total",
+		help:   "Count of processed TravisCI build requests",
+		kind:   counterKind,
+		labels: []string{"slug", "event_type", "result", "reason"},
+	}
+	jobsWaiting = &family{
+		name:   "travisci_jobs_waiting",
+		help:   "Count of TravisCI jobs waiting for a worker, by how long they've waited",
+		kind:   gaugeKind,
+		sum:    true,
+		labels: []string{"org", "queue", "age"},
+	}
+	activeBuilds = &family{
+		name:   "travisci_active_builds",
+		help:   "Count of TravisCI builds currently running",
+		kind:   gaugeKind,
+		sum:    true,
+		labels: []string{"org", "slug"},
+	}
+	activeJobs = &family{
+		name:   "travisci_active_jobs",
+		help:   "Count of TravisCI jobs in currently running builds",
+		kind:   gaugeKind,
+		sum:    true,
+		labels: []string{"org", "slug", "queue", "state"},
+	}
+	activeOldestBuildAge = &family{
+		name:   "travisci_active_oldest_build_age_seconds",
+		help:   "Seconds since the oldest currently running TravisCI build started",
+		kind:   gaugeKind,
+		labels: []string{"org"},
+	}
+	branchLastBuildPassed = &family{
+		name:   "travisci_branch_last_build_passed",
+		help:   "1 if the last finished TravisCI build of a branch passed, 0 if it failed or errored",
+		kind:   gaugeKind,
+		labels: []string{"slug", "branch", "default_branch"},
+	}
+	branchBrokenSince = &family{
+		name:   "travisci_branch_broken_since_timestamp_seconds",
+		help:   "Unix timestamp of when a TravisCI branch first went red, only present while it's still red",
+		kind:   gaugeKind,
+		labels: []string{"slug", "branch", "default_branch"},
+	}
+	cronNextRun = &family{
+		name:   "travisci_cron_next_run_timestamp_seconds",
+		help:   "Unix timestamp of when a TravisCI cron is next scheduled to run",
+		kind:   gaugeKind,
+		labels: []string{"slug", "branch"},
+	}
+	cronLastRun = &family{
+		name:   "travisci_cron_last_run_timestamp_seconds",
+		help:   "Unix timestamp of when a TravisCI cron last ran",
+		kind:   gaugeKind,
+		labels: []string{"slug", "branch"},
+	}
+	cronInterval = &family{
+		name:   "travisci_cron_interval_seconds",
+		help:   "Seconds between runs of a TravisCI cron",
+		kind:   gaugeKind,
+		labels: []string{"slug", "branch"},
+	}
+	cronActive = &family{
+		name:   "travisci_cron_active",
+		help:   "1 if a TravisCI cron is active, 0 otherwise",
+		kind:   gaugeKind,
+		labels: []string{"slug", "branch"},
+	}
+	cronMissedRun = &family{
+		name:   "travisci_cron_missed_run",
+		help:   "1 if an active TravisCI cron hasn't run within its interval plus the grace period",
+		kind:   gaugeKind,
+		labels: []string{"slug", "branch"},
+	}
+	cachesCount = &family{
+		name:   "travisci_caches",
+		help:   "Count of TravisCI build caches",
+		kind:   gaugeKind,
+		sum:    true,
+		labels: []string{"slug", "branch"},
+	}
+	cacheSizeBytes = &family{
+		name:   "travisci_cache_size_bytes",
+		help:   "Total size in bytes of TravisCI build caches",
+		kind:   gaugeKind,
+		sum:    true,
+		labels: []string{"slug", "branch"},
+	}
+	cacheLastModified = &family{
+		name:   "travisci_cache_last_modified_timestamp_seconds",
+		help:   "Unix timestamp of the most recently modified TravisCI build cache",
+		kind:   gaugeKind,
+		labels: []string{"slug", "branch"},
+	}
+	requestLastRejected = &family{
+		name:   "travisci_request_last_rejected_timestamp_seconds",
+		help:   "Unix timestamp of the most recent rejected TravisCI build request",
+		kind:   gaugeKind,
+		labels: []string{"slug"},
+	}
+	repoSettings = &family{
+		name:   "travisci_repo_setting",
+		help:   "Value of each boolean (1 or 0) and numeric TravisCI repository setting",
+		kind:   gaugeKind,
+		labels: []string{"slug", "setting"},
+	}
+	repoSettingsDrift = &family{
+		name:   "travisci_repo_setting_drift",
+		help:   "1 if a TravisCI repository setting differs from its desired value, 0 otherwise",
+		kind:   gaugeKind,
+		labels: []string{"slug", "setting"},
+	}
+	jobDurations = &family{
+		name:     "travisci_job_duration_seconds",
+		help:     "Duration in seconds of finished TravisCI jobs",
+		kind:     histogramKind,
+		labels:   []string{"slug", "branch", "event_type", "state"},
+		optional: []string{"queue"},
+	}
+	buildDurations = &family{
+		name:   "travisci_build_duration_seconds",
+		help:   "Duration in seconds of finished TravisCI builds",
+		kind:   histogramKind,
+		labels: []string{"slug", "branch", "event_type", "state"},
+	}
+	queueWaits = &family{
+		name:   "travisci_job_queue_wait_seconds",
+		help:   "Seconds TravisCI jobs waited between being created and started",
+		kind:   histogramKind,
+		labels: []string{"slug", "queue"},
+	}
+	stageDurations = &family{
+		name:   "travisci_stage_duration_seconds",
+		help:   "Duration in seconds of finished TravisCI build stages",
+		kind:   histogramKind,
+		labels: []string{"slug", "stage", "state"},
+	}
+
+	families = []*family{
+		buildsTotal, jobsTotal, stagesTotal, requestsTotal,
+		jobsWaiting, activeBuilds, activeJobs, activeOldestBuildAge,
+		branchLastBuildPassed, branchBrokenSince,
+		cronNextRun, cronLastRun, cronInterval, cronActive, cronMissedRun,
+		cachesCount, cacheSizeBytes, cacheLastModified,
+		requestLastRejected, repoSettings, repoSettingsDrift,
+		jobDurations, buildDurations, queueWaits, stageDurations,
+	}
+)
+
+// setupMetrics finishes each family from our config and registers the
+// collector serving them along with metrics about the exporter itself.
+func setupMetrics(hist histograms, c *collector) {
+	jobDurations.buckets = bucketsOrDefault(hist.JobDurationBuckets, defaultDurationBuckets)
+	buildDurations.buckets = bucketsOrDefault(hist.BuildDurationBuckets, defaultDurationBuckets)
+	queueWaits.buckets = bucketsOrDefault(hist.QueueWaitBuckets, defaultQueueWaitBuckets)
+	stageDurations.buckets = bucketsOrDefault(hist.StageDurationBuckets, defaultDurationBuckets)
+
+	for i := range families {
+		families[i].desc = prometheus.NewDesc(families[i].name, families[i].help, families[i].exportedLabels(), nil)
+	}
+
+	prometheus.MustRegister(c)
+	prometheus.MustRegister(exporterMetrics...)
+	buildInfo.WithLabelValues(version).Set(1)
+}
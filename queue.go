@@ -0,0 +1,81 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"time"
+
+	"github.com/shuheiktgw/go-travis"
+)
+
+var (
+	// waitingStates are the job states before a worker has picked up the job
+	waitingStates = map[string]bool{
+		travis.JobStatusCreated:  true,
+		travis.JobStatusQueued:   true,
+		travis.JobStatusReceived: true,
+	}
+
+	// queueAges are the age groups for travisci_jobs_waiting, the last
+	// one catches everything older.
+	queueAges = []struct {
+		max   time.Duration
+		label string
+	}{
+		{time.Minute, "0-1m"},
+		{5 * time.Minute, "1m-5m"},
+		{15 * time.Minute, "5m-15m"},
+		{time.Hour, "15m-1h"},
+		{0, "1h+"},
+	}
+)
+
+type waitingKey struct {
+	queue string
+	age   string
+}
+
+// queueAge returns the queueAges label for a job created at the given TravisCI timestamp.
+func queueAge(created string) string {
+	t, err := time.Parse(timestampFormat, created)
+	if err != nil {
+		return "unknown"
+	}
+	age := time.Since(t)
+	for i := range queueAges {
+		if queueAges[i].max == 0 || age < queueAges[i].max {
+			return queueAges[i].label
+		}
+	}
+	return "unknown"
+}
+
+// recordQueueWait observes how long job waited for a worker once it has started.
+func (c *checker) recordQueueWait(build *buildWithJobs, job *travis.Job) {
+	if job.StartedAt == "" || c.started.recorded(job.Id, job.StartedAt) {
+		return
+	}
+	started := happenedAt(job.StartedAt)
+	if started.Before(c.retentionCutoff()) {
+		return // older than our retention
+	}
+	c.started[job.Id] = started
+
+	dur, err := duration(job.CreatedAt, job.StartedAt)
+	if err != nil {
+		return
+	}
+	c.metrics.observe(queueWaits, dur.Seconds(), build.Repository.Slug, job.Queue)
+}
+
+// setWaiting replaces our travisci_jobs_waiting series with waiting, removing
+// any queue/age groups which no longer have jobs.
+func (c *checker) setWaiting(waiting map[waitingKey]int) {
+	var samples []gaugeSample
+	for k, n := range waiting {
+		samples = append(samples, gaugeSample{labels: []string{c.name, k.queue, k.age}, value: float64(n)})
+	}
+	c.metrics.replace(jobsWaiting, samples)
+}
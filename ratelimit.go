@@ -0,0 +1,149 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// limiters holds one tokenBucket per TravisCI token (by tokenSource.key),
+	// so organizations sharing a token also share its rate limit.
+	limitersMu sync.Mutex
+	limiters   = make(map[string]*tokenBucket)
+)
+
+// limiterFor returns the tokenBucket for key, creating it with rate and burst
+// if needed. An existing bucket keeps its limits until configureLimiters.
+func limiterFor(key string, rate float64, burst int) *tokenBucket {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	if b, exists := limiters[key]; exists {
+		return b
+	}
+	b := &tokenBucket{last: time.Now()}
+	b.configure(rate, burst)
+	b.tokens = b.burst
+	limiters[key] = b
+	return b
+}
+
+// configureLimiters gives the bucket of each organization's token the rate
+// limit of cfg. It's only called once cfg has been applied, so a rejected
+// reload leaves our running checkers with their limits.
+func configureLimiters(cfg *config) {
+	if cfg.API.RequestsPerSecond <= 0 {
+		return
+	}
+	for i := range cfg.Organizations {
+		key := newTokenSource(cfg.Organizations[i]).key()
+		limiterFor(key, cfg.API.RequestsPerSecond, cfg.API.Burst).configure(cfg.API.RequestsPerSecond, cfg.API.Burst)
+	}
+}
+
+// tokenBucket allows rate requests per second on average with bursts of up to burst requests.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// until is when TravisCI last asked us to hold off until
+	until time.Time
+}
+
+// configure sets the rate and burst of b, as they can change on reload.
+func (b *tokenBucket) configure(rate float64, burst int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if burst < 1 {
+		burst = 1
+	}
+	b.rate, b.burst = rate, float64(burst)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// hold delays every request waiting on b until at least until.
+func (b *tokenBucket) hold(until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until.After(b.until) {
+		b.until = until
+	}
+}
+
+// wait blocks until a request is allowed or ctx is done.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		if now.Before(b.until) {
+			delay := b.until.Sub(now)
+			b.mu.Unlock()
+			if err := sleep(ctx, delay); err != nil {
+				return err
+			}
+			continue
+		}
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitTransport waits on a tokenBucket before sending each request and
+// holds the bucket when TravisCI tells us to slow down.
+type rateLimitTransport struct {
+	limiter *tokenBucket
+	next    http.RoundTripper
+
+	// maxHold caps how long TravisCI can hold our bucket for
+	maxHold time.Duration
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if d, ok := retryAfter(resp, t.maxHold); ok {
+		t.limiter.hold(time.Now().Add(d))
+	}
+	return resp, err
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// secrets holds every token we've read so they can be kept out of our logs.
+var secrets = &secretSet{}
+
+type secretSet struct {
+	mu     sync.RWMutex
+	values [][]byte
+}
+
+func (s *secretSet) add(secret string) {
+	if secret == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.values {
+		if string(s.values[i]) == secret {
+			return
+		}
+	}
+	s.values = append(s.values, []byte(secret))
+}
+
+// redact replaces each secret in b with REDACTED.
+func (s *secretSet) redact(b []byte) []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := range s.values {
+		b = bytes.Replace(b, s.values[i], []byte("REDACTED"), -1)
+	}
+	return b
+}
+
+// redactWriter removes secrets from everything written to w, it's used as
+// our log output.
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r redactWriter) Write(p []byte) (int, error) {
+	if _, err := r.w.Write(secrets.redact(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
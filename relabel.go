@@ -0,0 +1,252 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	relabelReplace = "replace"
+	relabelKeep    = "keep"
+	relabelDrop    = "drop"
+	relabelHashMod = "hashmod"
+)
+
+// relabeler is a compiled relabelRule.
+type relabeler struct {
+	rule     relabelRule
+	regex    *regexp.Regexp
+	families map[string]bool
+}
+
+// compileRelabelRules checks each of rules and compiles their regexes, filling
+// in the same defaults as Prometheus.
+func compileRelabelRules(rules []relabelRule) ([]*relabeler, error) {
+	known := make(map[string]bool)
+	for i := range families {
+		known[families[i].name] = true
+	}
+
+	var out []*relabeler
+	for i, rule := range rules {
+		if rule.Action == "" {
+			rule.Action = relabelReplace
+		}
+		if rule.Separator == "" {
+			rule.Separator = ";"
+		}
+		if rule.Regex == "" {
+			rule.Regex = "(.*)"
+		}
+		if rule.Replacement == "" {
+			rule.Replacement = "$1"
+		}
+
+		switch rule.Action {
+		case relabelReplace:
+			if rule.TargetLabel == "" {
+				return nil, fmt.Errorf("labels.relabel[%d]: replace needs a target_label", i)
+			}
+		case relabelHashMod:
+			if rule.TargetLabel == "" || rule.Modulus == 0 {
+				return nil, fmt.Errorf("labels.relabel[%d]: hashmod needs a target_label and modulus", i)
+			}
+		case relabelKeep, relabelDrop:
+		default:
+			return nil, fmt.Errorf("labels.relabel[%d]: unknown action %q", i, rule.Action)
+		}
+		if len(rule.SourceLabels) == 0 {
+			return nil, fmt.Errorf("labels.relabel[%d]: source_labels is required", i)
+		}
+
+		regex, err := regexp.Compile("^(?:" + rule.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("labels.relabel[%d]: %v", i, err)
+		}
+
+		r := &relabeler{rule: rule, regex: regex}
+		if len(rule.Metrics) > 0 {
+			r.families = make(map[string]bool)
+			for _, name := range rule.Metrics {
+				if !known[name] {
+					return nil, fmt.Errorf("labels.relabel[%d]: unknown metric %s", i, name)
+				}
+				r.families[name] = true
+			}
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// appliesTo reports if r should run on series of f.
+func (r *relabeler) appliesTo(f *family) bool {
+	return r.families == nil || r.families[f.name]
+}
+
+// apply runs r against set, returning false if the series should be dropped.
+func (r *relabeler) apply(set map[string]string) bool {
+	values := make([]string, len(r.rule.SourceLabels))
+	for i, name := range r.rule.SourceLabels {
+		values[i] = set[name]
+	}
+	value := strings.Join(values, r.rule.Separator)
+
+	switch r.rule.Action {
+	case relabelKeep:
+		return r.regex.MatchString(value)
+	case relabelDrop:
+		return !r.regex.MatchString(value)
+	case relabelHashMod:
+		sum := md5.Sum([]byte(value))
+		set[r.rule.TargetLabel] = fmt.Sprintf("%d", binary.BigEndian.Uint64(sum[8:])%r.rule.Modulus)
+	case relabelReplace:
+		idx := r.regex.FindStringSubmatchIndex(value)
+		if idx != nil {
+			set[r.rule.TargetLabel] = string(r.regex.ExpandString(nil, r.rule.Replacement, value, idx))
+		}
+	}
+	return true
+}
+
+// setupLabels picks the labels each family exports and which relabel rules
+// run on its series.
+func setupLabels(cfg labelConfig) error {
+	rules, err := compileRelabelRules(cfg.Relabel)
+	if err != nil {
+		return err
+	}
+	for _, f := range families {
+		f.exported = nil
+		if keep, exists := cfg.Families[f.name]; exists {
+			f.exported = keep
+		}
+		f.rules = nil
+		for _, r := range rules {
+			if r.appliesTo(f) {
+				f.rules = append(f.rules, r)
+			}
+		}
+	}
+	return nil
+}
+
+// relabel runs the rules of f against values (ordered as f.labels and then
+// f.optional) and returns the values of the labels f exports, or false if the
+// series is dropped.
+func (f *family) relabel(values []string) ([]string, bool) {
+	if len(f.rules) == 0 && f.exported == nil {
+		return values[:len(f.labels)], true
+	}
+	set := make(map[string]string, len(values))
+	for i, name := range f.allLabels() {
+		set[name] = values[i]
+	}
+	for _, r := range f.rules {
+		if !r.apply(set) {
+			return nil, false
+		}
+	}
+	exported := f.exportedLabels()
+	out := make([]string, len(exported))
+	for i, name := range exported {
+		out[i] = set[name]
+	}
+	return out, true
+}
+
+// allLabels returns every label f can be exported with, its optional ones last.
+func (f *family) allLabels() []string {
+	if len(f.optional) == 0 {
+		return f.labels
+	}
+	out := make([]string, 0, len(f.labels)+len(f.optional))
+	out = append(out, f.labels...)
+	return append(out, f.optional...)
+}
+
+// exportedLabels returns the label names f is exported with.
+func (f *family) exportedLabels() []string {
+	if f.exported == nil {
+		return f.labels
+	}
+	return f.exported
+}
+
+// familyNamed returns the family we export as name, or nil.
+func familyNamed(name string) *family {
+	for i := range families {
+		if families[i].name == name {
+			return families[i]
+		}
+	}
+	return nil
+}
+
+// validateLabels checks that cfg only names metrics we export and their labels,
+// and that relabel rules only set labels which can be exported.
+func validateLabels(cfg labelConfig) error {
+	for name, keep := range cfg.Families {
+		f := familyNamed(name)
+		if f == nil {
+			return fmt.Errorf("labels.families: unknown metric %s", name)
+		}
+		listed := make(map[string]bool)
+		for _, label := range keep {
+			if listed[label] {
+				return fmt.Errorf("labels.families.%s: %s is listed more than once", name, label)
+			}
+			listed[label] = true
+
+			if !contains(f.allLabels(), label) {
+				return fmt.Errorf("labels.families.%s: %s has no label %s", name, name, label)
+			}
+		}
+		if f.kind == gaugeKind && !f.sum {
+			for _, label := range f.labels {
+				if !listed[label] {
+					return fmt.Errorf("labels.families.%s: labels can't be dropped from %s as its values can't be added up", name, name)
+				}
+			}
+		}
+	}
+
+	rules, err := compileRelabelRules(cfg.Relabel)
+	if err != nil {
+		return err
+	}
+	for i, r := range rules {
+		target := r.rule.TargetLabel
+		if target == "" || strings.HasPrefix(target, "__") {
+			continue // keep, drop or scratch space for later rules
+		}
+		exported := false
+		for _, f := range families {
+			labels := f.labels
+			if keep, exists := cfg.Families[f.name]; exists {
+				labels = keep
+			}
+			exported = exported || (r.appliesTo(f) && contains(labels, target))
+		}
+		if !exported {
+			return fmt.Errorf("labels.relabel[%d]: target_label %s isn't exported by any metric the rule runs on", i, target)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for i := range values {
+		if values[i] == value {
+			return true
+		}
+	}
+	return false
+}
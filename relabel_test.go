@@ -0,0 +1,306 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRelabeler_apply(t *testing.T) {
+	cases := []struct {
+		name string
+		rule relabelRule
+		set  map[string]string
+
+		keep bool
+		want map[string]string
+	}{
+		{
+			name: "keep match",
+			rule: relabelRule{Action: relabelKeep, SourceLabels: []string{"slug"}, Regex: "moov-io/.*"},
+			set:  map[string]string{"slug": "moov-io/ach"},
+			keep: true,
+			want: map[string]string{"slug": "moov-io/ach"},
+		},
+		{
+			name: "keep miss",
+			rule: relabelRule{Action: relabelKeep, SourceLabels: []string{"slug"}, Regex: "moov-io/.*"},
+			set:  map[string]string{"slug": "other/ach"},
+			keep: false,
+		},
+		{
+			name: "keep is anchored",
+			rule: relabelRule{Action: relabelKeep, SourceLabels: []string{"slug"}, Regex: "ach"},
+			set:  map[string]string{"slug": "moov-io/ach"},
+			keep: false,
+		},
+		{
+			name: "drop match",
+			rule: relabelRule{Action: relabelDrop, SourceLabels: []string{"branch"}, Regex: "dependabot/.*"},
+			set:  map[string]string{"branch": "dependabot/go"},
+			keep: false,
+		},
+		{
+			name: "drop miss",
+			rule: relabelRule{Action: relabelDrop, SourceLabels: []string{"branch"}, Regex: "dependabot/.*"},
+			set:  map[string]string{"branch": "master"},
+			keep: true,
+			want: map[string]string{"branch": "master"},
+		},
+		{
+			name: "drop joins source labels",
+			rule: relabelRule{Action: relabelDrop, SourceLabels: []string{"slug", "branch"}, Regex: "moov-io/ach;master"},
+			set:  map[string]string{"slug": "moov-io/ach", "branch": "master"},
+			keep: false,
+		},
+		{
+			name: "hashmod",
+			rule: relabelRule{Action: relabelHashMod, SourceLabels: []string{"slug"}, TargetLabel: "__shard", Modulus: 4},
+			set:  map[string]string{"slug": "moov-io/paygate"},
+			keep: true,
+			want: map[string]string{"slug": "moov-io/paygate", "__shard": "3"},
+		},
+		{
+			name: "replace",
+			rule: relabelRule{SourceLabels: []string{"branch"}, Regex: "release-(.*)", TargetLabel: "branch", Replacement: "release"},
+			set:  map[string]string{"branch": "release-1.2"},
+			keep: true,
+			want: map[string]string{"branch": "release"},
+		},
+		{
+			name: "replace with groups",
+			rule: relabelRule{SourceLabels: []string{"slug", "branch"}, Regex: "(.*)/.*;release-([0-9]+)\\..*", TargetLabel: "branch", Replacement: "$1-v$2"},
+			set:  map[string]string{"slug": "moov-io/ach", "branch": "release-1.2"},
+			keep: true,
+			want: map[string]string{"slug": "moov-io/ach", "branch": "moov-io-v1"},
+		},
+		{
+			name: "replace miss",
+			rule: relabelRule{SourceLabels: []string{"branch"}, Regex: "release-(.*)", TargetLabel: "branch", Replacement: "release"},
+			set:  map[string]string{"branch": "master"},
+			keep: true,
+			want: map[string]string{"branch": "master"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := compileRelabelRules([]relabelRule{tc.rule})
+			if err != nil {
+				t.Fatal(err)
+			}
+			keep := rules[0].apply(tc.set)
+			if keep != tc.keep {
+				t.Fatalf("apply() = %v, expected %v", keep, tc.keep)
+			}
+			if keep && !reflect.DeepEqual(tc.set, tc.want) {
+				t.Errorf("got labels %v, expected %v", tc.set, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompileRelabelRules__invalid(t *testing.T) {
+	cases := map[string]relabelRule{
+		"unknown action":     {Action: "labelmap", SourceLabels: []string{"slug"}},
+		"no source labels":   {Action: relabelKeep},
+		"replace no target":  {SourceLabels: []string{"slug"}},
+		"hashmod no modulus": {Action: relabelHashMod, SourceLabels: []string{"slug"}, TargetLabel: "__shard"},
+		"bad regex":          {Action: relabelKeep, SourceLabels: []string{"slug"}, Regex: "("},
+		"unknown metric":     {Action: relabelKeep, SourceLabels: []string{"slug"}, Metrics: []string{"travisci_nope"}},
+	}
+	for name, rule := range cases {
+		if _, err := compileRelabelRules([]relabelRule{rule}); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestFamily_relabel(t *testing.T) {
+	rules, err := compileRelabelRules([]relabelRule{
+		{Action: relabelDrop, SourceLabels: []string{"branch"}, Regex: "dependabot/.*"},
+		{SourceLabels: []string{"branch"}, Regex: "release-.*", TargetLabel: "branch", Replacement: "release"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		optional []string
+		exported []string
+		rules    []*relabeler
+		values   []string
+
+		keep bool
+		want []string
+	}{
+		{
+			name:   "untouched",
+			values: []string{"moov-io/ach", "master", "push"},
+			keep:   true,
+			want:   []string{"moov-io/ach", "master", "push"},
+		},
+		{
+			name:   "replaced",
+			rules:  rules,
+			values: []string{"moov-io/ach", "release-1.2", "push"},
+			keep:   true,
+			want:   []string{"moov-io/ach", "release", "push"},
+		},
+		{
+			name:   "dropped series",
+			rules:  rules,
+			values: []string{"moov-io/ach", "dependabot/go", "push"},
+			keep:   false,
+		},
+		{
+			name:     "dropped labels",
+			exported: []string{"slug", "event_type"},
+			values:   []string{"moov-io/ach", "master", "push"},
+			keep:     true,
+			want:     []string{"moov-io/ach", "push"},
+		},
+		{
+			name:     "replaced and dropped labels",
+			exported: []string{"branch"},
+			rules:    rules,
+			values:   []string{"moov-io/ach", "release-1.2", "push"},
+			keep:     true,
+			want:     []string{"release"},
+		},
+		{
+			name:     "optional labels off",
+			optional: []string{"queue"},
+			values:   []string{"moov-io/ach", "master", "push", "builds.gce"},
+			keep:     true,
+			want:     []string{"moov-io/ach", "master", "push"},
+		},
+		{
+			name:     "optional labels on",
+			optional: []string{"queue"},
+			exported: []string{"slug", "queue"},
+			values:   []string{"moov-io/ach", "master", "push", "builds.gce"},
+			keep:     true,
+			want:     []string{"moov-io/ach", "builds.gce"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &family{
+				name:     "travisci_test_total",
+				kind:     counterKind,
+				labels:   []string{"slug", "branch", "event_type"},
+				optional: tc.optional,
+				exported: tc.exported,
+				rules:    tc.rules,
+			}
+			got, keep := f.relabel(tc.values)
+			if keep != tc.keep {
+				t.Fatalf("relabel() kept %v, expected %v", keep, tc.keep)
+			}
+			if keep && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, expected %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	cases := []struct {
+		name    string
+		cfg     labelConfig
+		invalid bool
+	}{
+		{
+			name: "counter",
+			cfg:  labelConfig{Families: map[string][]string{buildsTotal.name: {"slug", "state"}}},
+		},
+		{
+			name: "gauge counting things",
+			cfg:  labelConfig{Families: map[string][]string{activeBuilds.name: {"slug"}}},
+		},
+		{
+			name:    "other gauge",
+			cfg:     labelConfig{Families: map[string][]string{branchBrokenSince.name: {"slug"}}},
+			invalid: true,
+		},
+		{
+			name:    "unknown metric",
+			cfg:     labelConfig{Families: map[string][]string{"travisci_nope": {"slug"}}},
+			invalid: true,
+		},
+		{
+			name:    "duplicate label",
+			cfg:     labelConfig{Families: map[string][]string{buildsTotal.name: {"slug", "slug"}}},
+			invalid: true,
+		},
+		{
+			name:    "duplicate label hiding a dropped one",
+			cfg:     labelConfig{Families: map[string][]string{branchBrokenSince.name: {"slug", "slug", "branch"}}},
+			invalid: true,
+		},
+		{
+			name:    "unknown label",
+			cfg:     labelConfig{Families: map[string][]string{buildsTotal.name: {"nope"}}},
+			invalid: true,
+		},
+		{
+			name: "optional label",
+			cfg:  labelConfig{Families: map[string][]string{jobsTotal.name: {"slug", "state", "branch", "queue"}}},
+		},
+		{
+			name: "target label exported",
+			cfg: labelConfig{Relabel: []relabelRule{
+				{SourceLabels: []string{"branch"}, Regex: "release-.*", TargetLabel: "branch", Replacement: "release"},
+			}},
+		},
+		{
+			name: "target label scratch space",
+			cfg: labelConfig{Relabel: []relabelRule{
+				{Action: relabelHashMod, SourceLabels: []string{"slug"}, TargetLabel: "__shard", Modulus: 2},
+			}},
+		},
+		{
+			name:    "target label never exported",
+			cfg:     labelConfig{Relabel: []relabelRule{{SourceLabels: []string{"slug"}, TargetLabel: "repo"}}},
+			invalid: true,
+		},
+		{
+			name: "target label not on the rule's metrics",
+			cfg: labelConfig{Relabel: []relabelRule{
+				{SourceLabels: []string{"slug"}, TargetLabel: "queue", Metrics: []string{buildsTotal.name}},
+			}},
+			invalid: true,
+		},
+		{
+			name: "target label optional and off",
+			cfg: labelConfig{Relabel: []relabelRule{
+				{SourceLabels: []string{"queue"}, TargetLabel: "queue", Metrics: []string{jobsTotal.name}},
+			}},
+			invalid: true,
+		},
+		{
+			name: "target label optional and on",
+			cfg: labelConfig{
+				Families: map[string][]string{jobsTotal.name: {"slug", "state", "queue"}},
+				Relabel: []relabelRule{
+					{SourceLabels: []string{"queue"}, TargetLabel: "queue", Metrics: []string{jobsTotal.name}},
+				},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateLabels(tc.cfg)
+			if tc.invalid && err == nil {
+				t.Error("expected error")
+			}
+			if !tc.invalid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// exporter runs a checker for each organization in our config, reconciling
+// them as the config is reloaded.
+type exporter struct {
+	ctx     context.Context
+	path    string
+	metrics *collector
+
+	interval, activeInterval, cachesInterval, reposInterval time.Duration
+
+	mu      sync.Mutex
+	cfg     *config
+	running map[string]*runningChecker
+}
+
+// runningChecker is a checker along with what's needed to stop it.
+type runningChecker struct {
+	org    organization
+	check  *checker
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// apply reconciles our checkers with cfg. Organizations which are new or
+// changed get a new checker, removed ones are stopped and the rest keep
+// running (and their metrics) with cfg's crons and settings. Clients and owners
+// for cfg are set up before anything is stopped, so on error the checkers of our
+// current config keep running.
+func (e *exporter) apply(cfg *config) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.cfg != nil {
+		if !reflect.DeepEqual(e.cfg.Labels, cfg.Labels) || !reflect.DeepEqual(e.cfg.Histograms, cfg.Histograms) {
+			return errors.New("labels and histograms can't change without a restart")
+		}
+	}
+	restartAll := e.cfg == nil || !sameShared(e.cfg, cfg)
+
+	next := make(map[string]*runningChecker)
+	var starting []*runningChecker
+	for i := range cfg.Organizations {
+		org := cfg.Organizations[i]
+		if rc, exists := e.running[org.Name]; exists && !restartAll && sameOrg(rc.org, org) {
+			next[org.Name] = rc
+			continue
+		}
+		check, err := e.newChecker(org, cfg)
+		if err != nil {
+			return err
+		}
+		rc := &runningChecker{org: org, check: check}
+		next[org.Name] = rc
+		starting = append(starting, rc)
+	}
+
+	for name, rc := range e.running {
+		if next[name] != rc {
+			log.Printf("stopping checks of %s", name)
+			e.stop(rc)
+		}
+	}
+	configureLimiters(cfg)
+	for _, rc := range next {
+		rc.check.setConfig(cfg)
+	}
+	for _, rc := range starting {
+		log.Printf("starting checks of %s", rc.org.Name)
+		e.start(rc)
+	}
+	e.cfg = cfg
+	e.running = next
+	return nil
+}
+
+// newChecker creates the client and checker of org, finding its owner.
+func (e *exporter) newChecker(org organization, cfg *config) (*checker, error) {
+	client, err := newClient(org, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating TravisCI client: %v", err)
+	}
+
+	// Only report on the owner we're configured for, even if the token can see others
+	owner, resp, err := client.Owner.FindByLogin(e.ctx, org.Name)
+	if resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("finding TravisCI owner %s: %v", org.Name, err)
+	}
+
+	return newChecker(org, owner, client, cfg, e.interval, e.activeInterval, e.cachesInterval, e.reposInterval), nil
+}
+
+func (e *exporter) start(rc *runningChecker) {
+	ctx, cancel := context.WithCancel(e.ctx)
+	rc.cancel = cancel
+	e.metrics.add(rc.check)
+	rc.check.start(ctx, &rc.wg)
+}
+
+// stop cancels the loops of rc, waits for them to return and removes its metrics.
+func (e *exporter) stop(rc *runningChecker) {
+	rc.cancel()
+	rc.wg.Wait()
+
+	e.metrics.remove(rc.check)
+	for _, check := range []string{"builds", "active", "caches", "repos"} {
+		lastSuccessfulPoll.DeleteLabelValues(rc.org.Name, check)
+	}
+}
+
+// wait blocks until every running checker has returned, which happens once
+// our context is canceled.
+func (e *exporter) wait() {
+	e.mu.Lock()
+	var running []*runningChecker
+	for _, rc := range e.running {
+		running = append(running, rc)
+	}
+	e.mu.Unlock()
+
+	for _, rc := range running {
+		rc.wg.Wait()
+	}
+}
+
+// reload reads our config file again and applies it, recording the result.
+func (e *exporter) reload() error {
+	cfg, err := readConfig(e.path)
+	if err == nil {
+		err = e.apply(cfg)
+	}
+	if err != nil {
+		log.Printf("ERROR reloading config: %v", err)
+		configReloads.WithLabelValues("failure").Inc()
+		configLastReloadSuccessful.Set(0)
+		return err
+	}
+	log.Printf("reloaded config %s", e.path)
+	configReloads.WithLabelValues("success").Inc()
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccess.SetToCurrentTime()
+	return nil
+}
+
+// reloadHandler reloads our config on POST /-/reload
+func (e *exporter) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := e.reload(); err != nil {
+		// Errors can quote our config or a token_command's output (i.e. a token
+		// we haven't seen yet), so they're only written to our redacted logs.
+		http.Error(w, "failed to reload config, see the exporter's logs", http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "config reloaded")
+}
+
+// watch reloads our config whenever its file changes, checking every interval.
+func (e *exporter) watch(interval time.Duration) {
+	var last time.Time
+	if info, err := os.Stat(e.path); err == nil {
+		last = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(e.path)
+			if err != nil {
+				log.Printf("ERROR watching config: %v", err)
+				continue
+			}
+			if info.ModTime().Equal(last) {
+				continue
+			}
+			last = info.ModTime()
+			e.reload()
+		case <-e.ctx.Done():
+			return
+		}
+	}
+}
+
+// sameOrg reports if a and b would be checked the same way.
+func sameOrg(a, b organization) bool {
+	a.matcher, b.matcher = nil, nil // compiled from Repos
+	return reflect.DeepEqual(a, b)
+}
+
+// sameShared reports if a and b share the client and paging settings every
+// checker uses. Crons and settings are swapped into running checkers instead.
+func sameShared(a, b *config) bool {
+	return reflect.DeepEqual(a.API, b.API) &&
+		reflect.DeepEqual(a.Builds, b.Builds)
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// checkReposAll checks the crons, settings, branches and build requests of our
+// repositories less often than our builds, as they rarely change (or, for
+// requests, can't be found from our builds).
+func (c *checker) checkReposAll(ctx context.Context) {
+	select {
+	case <-c.ready: // wait until we know our repositories
+	case <-ctx.Done():
+		return
+	}
+
+	if c.reposTicker == nil {
+		c.reposTicker = time.NewTicker(c.reposInterval)
+		c.pollRepos(ctx) // check right away after ticker setup
+	}
+	defer c.reposTicker.Stop()
+	for {
+		select {
+		case <-c.reposTicker.C:
+			c.pollRepos(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pollRepos runs checkReposNow with a deadline of our repos interval.
+func (c *checker) pollRepos(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, c.reposInterval)
+	defer cancel()
+
+	defer c.observePoll("repos", time.Now())
+	c.checkReposNow(ctx)
+}
+
+func (c *checker) checkReposNow(ctx context.Context) {
+	crons := c.checkCrons(ctx)
+	settings := c.checkSettings(ctx)
+	branches := c.checkBranches(ctx, c.repoSlugs())
+	requests := c.checkRequests(ctx)
+	if crons && settings && branches && requests {
+		c.succeeded("repos")
+	}
+	c.publish()
+}
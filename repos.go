@@ -0,0 +1,117 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// slugPattern matches repository slugs against either a glob (e.g. moov-io/*)
+// or, when wrapped in slashes, a regex (e.g. /^moov-io/(ach|wire)$/).
+type slugPattern struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+func newSlugPattern(pattern string) (*slugPattern, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		regex, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		return &slugPattern{regex: regex}, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return &slugPattern{glob: pattern}, nil
+}
+
+func (p *slugPattern) match(slug string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(slug)
+	}
+	matched, _ := path.Match(p.glob, slug)
+	return matched
+}
+
+// repoMatcher decides which repositories of an organization we report on.
+// A nil repoMatcher allows every repository.
+type repoMatcher struct {
+	include []*slugPattern
+	exclude []*slugPattern
+
+	skipForks    bool
+	skipPrivate  bool
+	skipInactive bool
+}
+
+func newRepoMatcher(filter repoFilter) (*repoMatcher, error) {
+	m := &repoMatcher{
+		skipForks:    filter.SkipForks,
+		skipPrivate:  filter.SkipPrivate,
+		skipInactive: filter.SkipInactive,
+	}
+	for _, pattern := range filter.Include {
+		p, err := newSlugPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("repos.include %q: %v", pattern, err)
+		}
+		m.include = append(m.include, p)
+	}
+	for _, pattern := range filter.Exclude {
+		p, err := newSlugPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("repos.exclude %q: %v", pattern, err)
+		}
+		m.exclude = append(m.exclude, p)
+	}
+	return m, nil
+}
+
+// allowed reports if repo matches one of our includes (when we have any),
+// none of our excludes and isn't a fork, private or inactive when those are skipped.
+func (m *repoMatcher) allowed(repo repository) bool {
+	if m == nil {
+		return true
+	}
+	if len(m.include) > 0 && !matchAny(m.include, repo.Slug) {
+		return false
+	}
+	if matchAny(m.exclude, repo.Slug) {
+		return false
+	}
+	if (m.skipForks && repo.Fork) || (m.skipPrivate && repo.Private) || (m.skipInactive && !repo.Active) {
+		return false
+	}
+	return true
+}
+
+func matchAny(patterns []*slugPattern, slug string) bool {
+	for i := range patterns {
+		if patterns[i].match(slug) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterBuilds returns the builds from repositories of our owner which we report on.
+func (c *checker) filterBuilds(builds []buildWithJobs) []buildWithJobs {
+	var out []buildWithJobs
+	for i := range builds {
+		repo := builds[i].Repository
+		if c.owner != nil && repo.Owner.Id != c.owner.Id {
+			continue // another owner the token can see
+		}
+		if c.filter.allowed(repo) {
+			out = append(out, builds[i])
+		}
+	}
+	return out
+}
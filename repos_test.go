@@ -0,0 +1,134 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/shuheiktgw/go-travis"
+)
+
+func TestSlugPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		slug    string
+		want    bool
+	}{
+		{"moov-io/ach", "moov-io/ach", true},
+		{"moov-io/ach", "moov-io/ach-node", false},
+		{"moov-io/*", "moov-io/ach", true},
+		{"moov-io/*", "other/ach", false},
+		{"*/ach", "moov-io/ach", true},
+		{"*", "moov-io/ach", false}, // * doesn't match across the slash
+		{"*-sandbox", "moov-io/ach-sandbox", false},
+		{"*/*-sandbox", "moov-io/ach-sandbox", true},
+		{"moov-io/ach-?", "moov-io/ach-2", true},
+		{"moov-io/[aw]*", "moov-io/wire", true},
+		{"/^moov-io/(ach|wire)$/", "moov-io/wire", true},
+		{"/^moov-io/(ach|wire)$/", "moov-io/paygate", false},
+		{"/ach/", "moov-io/ach-node", true}, // regexes aren't anchored
+		{"/", "/", true},                    // too short for a regex, so a glob
+	}
+	for _, tc := range cases {
+		p, err := newSlugPattern(tc.pattern)
+		if err != nil {
+			t.Errorf("%s: %v", tc.pattern, err)
+			continue
+		}
+		if got := p.match(tc.slug); got != tc.want {
+			t.Errorf("%s matching %s: got %v, expected %v", tc.pattern, tc.slug, got, tc.want)
+		}
+	}
+}
+
+func TestSlugPattern__invalid(t *testing.T) {
+	for _, pattern := range []string{"moov-io/[", "/(/"} {
+		if _, err := newSlugPattern(pattern); err == nil {
+			t.Errorf("%s: expected error", pattern)
+		}
+	}
+}
+
+func TestRepoMatcher_allowed(t *testing.T) {
+	repo := func(slug string, fork, private, active bool) repository {
+		return repository{
+			MinimalRepository: travis.MinimalRepository{Slug: slug},
+			Fork:              fork,
+			Private:           private,
+			Active:            active,
+		}
+	}
+
+	cases := []struct {
+		name   string
+		filter repoFilter
+		repo   repository
+		want   bool
+	}{
+		{
+			name: "empty filter",
+			repo: repo("moov-io/ach", true, true, false),
+			want: true,
+		},
+		{
+			name:   "included",
+			filter: repoFilter{Include: []string{"moov-io/a*", "moov-io/wire"}},
+			repo:   repo("moov-io/wire", false, false, true),
+			want:   true,
+		},
+		{
+			name:   "not included",
+			filter: repoFilter{Include: []string{"moov-io/a*"}},
+			repo:   repo("moov-io/wire", false, false, true),
+		},
+		{
+			name:   "excluded",
+			filter: repoFilter{Exclude: []string{"/-node$/"}},
+			repo:   repo("moov-io/ach-node", false, false, true),
+		},
+		{
+			name:   "exclude wins over include",
+			filter: repoFilter{Include: []string{"moov-io/*"}, Exclude: []string{"moov-io/ach-*"}},
+			repo:   repo("moov-io/ach-node", false, false, true),
+		},
+		{
+			name:   "fork skipped",
+			filter: repoFilter{SkipForks: true},
+			repo:   repo("moov-io/ach", true, false, true),
+		},
+		{
+			name:   "fork kept",
+			filter: repoFilter{SkipPrivate: true, SkipInactive: true},
+			repo:   repo("moov-io/ach", true, false, true),
+			want:   true,
+		},
+		{
+			name:   "private skipped",
+			filter: repoFilter{SkipPrivate: true},
+			repo:   repo("moov-io/ach", false, true, true),
+		},
+		{
+			name:   "inactive skipped",
+			filter: repoFilter{SkipInactive: true},
+			repo:   repo("moov-io/ach", false, false, false),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := newRepoMatcher(tc.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.allowed(tc.repo); got != tc.want {
+				t.Errorf("got %v, expected %v", got, tc.want)
+			}
+		})
+	}
+
+	var m *repoMatcher
+	if !m.allowed(repo("moov-io/ach", true, true, false)) {
+		t.Error("expected a nil repoMatcher to allow every repository")
+	}
+}
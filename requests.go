@@ -0,0 +1,114 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shuheiktgw/go-travis"
+)
+
+const requestResultRejected = "rejected"
+
+var (
+	// rejectionReasons normalize the free-form message of a rejected request
+	// into a bounded label, the first matching pattern wins.
+	rejectionReasons = []struct {
+		pattern *regexp.Regexp
+		reason  string
+	}{
+		{regexp.MustCompile(`branch .* not included`), "branch_not_included"},
+		{regexp.MustCompile(`branch .* excluded`), "branch_excluded"},
+		{regexp.MustCompile(`\[ci skip\]|\[skip ci\]|skipped`), "skipped"},
+		{regexp.MustCompile(`\.travis\.yml.*(missing|not found)|missing.*\.travis\.yml|config.*(empty|required)`), "config_missing"},
+		{regexp.MustCompile(`parse|syntax|invalid|yaml`), "config_invalid"},
+		{regexp.MustCompile(`(not|no) .*jobs|any jobs`), "no_jobs"},
+		{regexp.MustCompile(`limit|credit|plan|subscription|billing`), "plan_limit"},
+		{regexp.MustCompile(`pull request.* disabled|pushes.* disabled|builds.* disabled`), "event_disabled"},
+		{regexp.MustCompile(`not active|inactive|deactivated|disabled`), "repository_inactive"},
+		{regexp.MustCompile(`authoriz|permission|denied`), "unauthorized"},
+	}
+)
+
+// rejectionReason returns the label for a rejected request's message.
+func rejectionReason(message string) string {
+	message = strings.ToLower(strings.TrimSpace(message))
+	if message == "" {
+		return "unknown"
+	}
+	for i := range rejectionReasons {
+		if rejectionReasons[i].pattern.MatchString(message) {
+			return rejectionReasons[i].reason
+		}
+	}
+	return "other"
+}
+
+// checkRequests counts the build requests of our repositories which have been
+// processed since our last check and tracks when each repository last had one
+// rejected. It returns false if every repository failed.
+func (c *checker) checkRequests(ctx context.Context) bool {
+	slugs := c.repoSlugs()
+	listed := make([][]travis.Request, len(slugs))
+	read := make([]bool, len(slugs))
+	c.parallel(ctx, len(slugs), func(i int) {
+		requests, resp, err := c.client.Requests.ListByRepoSlug(ctx, slugs[i], &travis.ListRequestsOption{
+			Limit: 100,
+		})
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			log.Printf("ERROR: %s requests for %s from travis-ci api: %v", c.name, slugs[i], err)
+			return
+		}
+		listed[i], read[i] = requests, true
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cutoff := c.retentionCutoff()
+	ok := len(slugs) == 0
+	for i, slug := range slugs {
+		ok = ok || read[i]
+		for k := range listed[i] {
+			req := listed[i][k]
+			if req.Result == "" {
+				continue // not processed yet
+			}
+			if req.Result == requestResultRejected {
+				if created, err := time.Parse(timestampFormat, req.CreatedAt); err == nil && created.After(c.lastRejected[slug]) {
+					c.lastRejected[slug] = created
+				}
+			}
+			if c.requests.has(req.Id) {
+				continue // already counted
+			}
+			created := happenedAt(req.CreatedAt)
+			if created.Before(cutoff) {
+				continue // older than our retention
+			}
+			c.requests[req.Id] = created
+
+			var reason string
+			if req.Result == requestResultRejected {
+				reason = rejectionReason(req.Message)
+			}
+			c.metrics.inc(requestsTotal, slug, req.EventType, req.Result, reason)
+		}
+	}
+
+	var samples []gaugeSample
+	for slug, t := range c.lastRejected {
+		samples = append(samples, gaugeSample{labels: []string{slug}, value: float64(t.Unix())})
+	}
+	c.metrics.replace(requestLastRejected, samples)
+	return ok
+}
@@ -0,0 +1,43 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestRejectionReason(t *testing.T) {
+	cases := []struct {
+		message string
+		want    string
+	}{
+		{"", "unknown"},
+		{"   ", "unknown"},
+		{`Branch "gh-pages" not included per configuration.`, "branch_not_included"},
+		{`Branch "gh-pages" excluded per configuration.`, "branch_excluded"},
+		{"Build skipped via commit message [ci skip]", "skipped"},
+		{"[skip ci] update docs", "skipped"},
+		{".travis.yml is missing", "config_missing"},
+		{"Missing .travis.yml", "config_missing"},
+		{"Config is empty", "config_missing"},
+		{"Could not parse .travis.yml", "config_invalid"},
+		{"YAML syntax error", "config_invalid"},
+		{"Build config did not create any jobs", "no_jobs"},
+		{"No jobs were created", "no_jobs"},
+		{"Plan limit reached", "plan_limit"},
+		{"Not enough credits", "plan_limit"},
+		{"Builds for pull requests are disabled", "event_disabled"},
+		{"Pushes are disabled for this repository", "event_disabled"},
+		{"Repository not active", "repository_inactive"},
+		{"Repository was deactivated", "repository_inactive"},
+		{"Permission denied", "unauthorized"},
+		{"Something else went wrong", "other"},
+	}
+	for _, tc := range cases {
+		if got := rejectionReason(tc.message); got != tc.want {
+			t.Errorf("%q: got %s, expected %s", tc.message, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"time"
+)
+
+// seen holds the ids we've already recorded along with when each one
+// happened, so ids can be forgotten once they're older than our retention.
+type seen map[uint]time.Time
+
+// has reports if id has already been recorded.
+func (s seen) has(id uint) bool {
+	_, exists := s[id]
+	return exists
+}
+
+// recorded reports if id has already been recorded as happening at ts (a
+// TravisCI timestamp). An id recorded at another time has happened again,
+// e.g. a build which was restarted.
+func (s seen) recorded(id uint, ts string) bool {
+	at, exists := s[id]
+	if !exists {
+		return false
+	}
+	t, err := time.Parse(timestampFormat, ts)
+	return err != nil || t.Equal(at)
+}
+
+// prune forgets every id which happened before cutoff.
+func (s seen) prune(cutoff time.Time) {
+	for id, t := range s {
+		if t.Before(cutoff) {
+			delete(s, id)
+		}
+	}
+}
+
+// retentionCutoff returns the oldest time a job, build, stage or request can
+// have happened at and still be recorded.
+func (c *checker) retentionCutoff() time.Time {
+	retention := c.config().Builds.Retention
+	if retention == 0 {
+		retention = defaultBuildsRetention
+	}
+	return time.Now().Add(-1 * retention)
+}
+
+// happenedAt parses ts from the TravisCI API. Anything we can't parse is
+// treated as happening now, so it's kept for a full retention window.
+func happenedAt(ts string) time.Time {
+	t, err := time.Parse(timestampFormat, ts)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
+
+// buildStarted returns when build started, or was last updated if it hasn't
+// started yet.
+func buildStarted(build *buildWithJobs) time.Time {
+	if build.StartedAt != "" {
+		return happenedAt(build.StartedAt)
+	}
+	return happenedAt(build.UpdatedAt)
+}
+
+// prune forgets ids and counter or histogram series which are older than our
+// retention window, so a long running exporter doesn't grow without bound.
+// Builds stuck unfinished for longer than it are no longer looked up and
+// repositories we no longer check are forgotten.
+func (c *checker) prune() {
+	cutoff := c.retentionCutoff()
+
+	c.builds.prune(cutoff)
+	c.jobs.prune(cutoff)
+	c.stages.prune(cutoff)
+	c.started.prune(cutoff)
+	c.unfinished.prune(cutoff)
+
+	c.metrics.expire(cutoff)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests.prune(cutoff)
+
+	for key := range c.branches {
+		if !c.repos[key.slug] {
+			delete(c.branches, key)
+		}
+	}
+	for slug := range c.lastRejected {
+		if !c.repos[slug] {
+			delete(c.lastRejected, slug)
+		}
+	}
+	for slug := range c.highWater {
+		if !c.repos[slug] {
+			delete(c.highWater, slug)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryTransport retries GET requests which fail or come back throttled (429)
+// or with a server error (5xx). Each retry waits an exponential backoff with
+// jitter, unless TravisCI tells us how long to wait.
+type retryTransport struct {
+	attempts   int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	next       http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= t.attempts || !retryable(req, resp, err) {
+			return resp, err
+		}
+
+		delay, ok := retryAfter(resp, t.maxBackoff)
+		if !ok {
+			delay = t.backoff(attempt)
+		}
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return resp, err // we'd run out of time before trying again
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err := sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff doubles minBackoff for each attempt (up to maxBackoff) and picks a
+// random delay between half and all of that so retries from each worker spread out.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	d := t.minBackoff
+	for i := 1; i < attempt && d < t.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > t.maxBackoff {
+		d = t.maxBackoff
+	}
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// retryable reports if req is safe to send again and the response looks temporary.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Context().Err() != nil {
+		return false // our caller gave up
+	}
+	if err != nil {
+		return true
+	}
+	return temporaryStatus(resp)
+}
+
+// temporaryStatus reports if resp is TravisCI throttling us or having trouble.
+func temporaryStatus(resp *http.Response) bool {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true
+	case resp.StatusCode == http.StatusForbidden && resp.Header.Get("X-RateLimit-Remaining") == "0":
+		return true
+	}
+	return false
+}
+
+// retryAfter returns how long TravisCI has asked us to wait, from either the
+// Retry-After header (seconds or an HTTP date) or X-RateLimit-Reset (unix
+// seconds) once X-RateLimit-Remaining hits zero. It's capped at max.
+func retryAfter(resp *http.Response, max time.Duration) (time.Duration, bool) {
+	d, ok := requestedDelay(resp)
+	if ok && d > max {
+		d = max
+	}
+	return d, ok
+}
+
+func requestedDelay(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second, true
+		}
+		if when, err := http.ParseTime(v); err == nil {
+			return untilOrZero(when), true
+		}
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if secs, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return untilOrZero(time.Unix(secs, 0)), true
+		}
+	}
+	return 0, false
+}
+
+func untilOrZero(when time.Time) time.Duration {
+	if d := time.Until(when); d > 0 {
+		return d
+	}
+	return 0
+}
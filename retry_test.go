@@ -0,0 +1,127 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name    string
+		headers map[string]string
+
+		ok   bool
+		want time.Duration
+	}{
+		{
+			name: "no headers",
+		},
+		{
+			name:    "retry-after seconds",
+			headers: map[string]string{"Retry-After": "5"},
+			ok:      true,
+			want:    5 * time.Second,
+		},
+		{
+			name:    "retry-after date",
+			headers: map[string]string{"Retry-After": now.Add(10 * time.Second).UTC().Format(http.TimeFormat)},
+			ok:      true,
+			want:    10 * time.Second,
+		},
+		{
+			name:    "retry-after date passed",
+			headers: map[string]string{"Retry-After": now.Add(-time.Hour).UTC().Format(http.TimeFormat)},
+			ok:      true,
+			want:    0,
+		},
+		{
+			name:    "retry-after invalid",
+			headers: map[string]string{"Retry-After": "soon"},
+		},
+		{
+			name:    "retry-after capped",
+			headers: map[string]string{"Retry-After": "3600"},
+			ok:      true,
+			want:    30 * time.Second,
+		},
+		{
+			name: "rate limit reset",
+			headers: map[string]string{
+				"X-RateLimit-Remaining": "0",
+				"X-RateLimit-Reset":     fmt.Sprintf("%d", now.Add(20*time.Second).Unix()),
+			},
+			ok:   true,
+			want: 20 * time.Second,
+		},
+		{
+			name: "rate limit reset capped",
+			headers: map[string]string{
+				"X-RateLimit-Remaining": "0",
+				"X-RateLimit-Reset":     fmt.Sprintf("%d", now.Add(time.Hour).Unix()),
+			},
+			ok:   true,
+			want: 30 * time.Second,
+		},
+		{
+			name: "rate limit remaining",
+			headers: map[string]string{
+				"X-RateLimit-Remaining": "10",
+				"X-RateLimit-Reset":     fmt.Sprintf("%d", now.Add(time.Hour).Unix()),
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{Header: make(http.Header)}
+			for k, v := range tc.headers {
+				resp.Header.Set(k, v)
+			}
+			got, ok := retryAfter(resp, 30*time.Second)
+			if ok != tc.ok {
+				t.Fatalf("retryAfter() ok = %v, expected %v", ok, tc.ok)
+			}
+			// dates are only accurate to the second
+			if got > tc.want || got < tc.want-time.Second {
+				t.Errorf("got %v, expected %v", got, tc.want)
+			}
+		})
+	}
+
+	if _, ok := retryAfter(nil, time.Minute); ok {
+		t.Error("expected no delay without a response")
+	}
+}
+
+func TestTemporaryStatus(t *testing.T) {
+	cases := []struct {
+		code      int
+		remaining string
+		want      bool
+	}{
+		{code: http.StatusOK},
+		{code: http.StatusNotFound},
+		{code: http.StatusForbidden},
+		{code: http.StatusForbidden, remaining: "1"},
+		{code: http.StatusForbidden, remaining: "0", want: true},
+		{code: http.StatusTooManyRequests, want: true},
+		{code: http.StatusInternalServerError, want: true},
+		{code: http.StatusBadGateway, want: true},
+		{code: http.StatusServiceUnavailable, want: true},
+	}
+	for _, tc := range cases {
+		resp := &http.Response{StatusCode: tc.code, Header: make(http.Header)}
+		if tc.remaining != "" {
+			resp.Header.Set("X-RateLimit-Remaining", tc.remaining)
+		}
+		if got := temporaryStatus(resp); got != tc.want {
+			t.Errorf("%d (X-RateLimit-Remaining: %q): got %v, expected %v", tc.code, tc.remaining, got, tc.want)
+		}
+	}
+}
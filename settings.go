@@ -0,0 +1,74 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// checkSettings replaces the travisci_repo_setting* gauges with the settings of
+// our repositories, comparing each against the desired settings from our config.
+// Repositories we couldn't read keep their last settings. It returns false if
+// every repository failed.
+func (c *checker) checkSettings(ctx context.Context) bool {
+	desired := c.config().Settings.Desired
+	slugs := c.repoSlugs()
+	read := 0
+	for _, slug := range slugs {
+		if ctx.Err() != nil {
+			break // out of time, skip the rest
+		}
+		settings, resp, err := c.client.Settings.ListByRepoSlug(ctx, slug)
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			log.Printf("ERROR: %s settings for %s from travis-ci api: %v", c.name, slug, err)
+			continue // keep what we knew about this repo's settings
+		}
+		read++
+
+		var values, drift []gaugeSample
+		current := make(map[string]float64)
+		for i := range settings {
+			if v, ok := settingValue(settings[i].Value); ok {
+				current[settings[i].Name] = v
+				values = append(values, gaugeSample{labels: []string{slug, settings[i].Name}, value: v})
+			}
+		}
+		for name, v := range desired {
+			want, _ := settingValue(v) // checked in config.validate
+			if got, exists := current[name]; exists && got == want {
+				drift = append(drift, gaugeSample{labels: []string{slug, name}, value: 0})
+			} else {
+				drift = append(drift, gaugeSample{labels: []string{slug, name}, value: 1})
+			}
+		}
+		c.settingGauges.set(repoSettings, slug, values)
+		c.settingGauges.set(repoSettingsDrift, slug, drift)
+	}
+	c.settingGauges.retain(slugs)
+
+	c.metrics.replace(repoSettings, c.settingGauges.samples(repoSettings))
+	c.metrics.replace(repoSettingsDrift, c.settingGauges.samples(repoSettingsDrift))
+	return read > 0 || len(slugs) == 0
+}
+
+// settingValue converts a boolean or numeric setting into a gauge value.
+func settingValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,29 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+// recordStages counts and observes the duration of each stage in build which
+// has finished since our last check.
+func (c *checker) recordStages(build *buildWithJobs) {
+	cutoff := c.retentionCutoff()
+	for i := range build.Stages {
+		stage := build.Stages[i]
+		if stage.FinishedAt == "" || c.stages.recorded(stage.Id, stage.FinishedAt) {
+			continue
+		}
+		finished := happenedAt(stage.FinishedAt)
+		if finished.Before(cutoff) {
+			continue // older than our retention
+		}
+		c.stages[stage.Id] = finished
+		c.metrics.inc(stagesTotal, build.Repository.Slug, stage.Name, stage.State)
+
+		dur, err := duration(stage.StartedAt, stage.FinishedAt)
+		if err != nil {
+			continue
+		}
+		c.metrics.observe(stageDurations, dur.Seconds(), build.Repository.Slug, stage.Name, stage.State)
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricSet holds the current value of every series a checker exports. It's
+// written by the checker's loops and copied into a snapshot for scrapes.
+type metricSet struct {
+	mu      sync.Mutex
+	series  map[*family]map[string]*series
+	updated map[string]time.Time
+}
+
+// series is one labeled value of a family. Counters and gauges use value
+// while histograms use count, sum and (cumulative) buckets.
+type series struct {
+	labels []string
+	value  float64
+
+	// updated is when a value was last recorded
+	updated time.Time
+
+	count   uint64
+	sum     float64
+	buckets []uint64
+}
+
+type gaugeSample struct {
+	labels []string
+	value  float64
+}
+
+// repoGauges holds the last samples read for each repository, so a repository
+// we couldn't read (or ran out of time for) keeps what we last knew of it rather
+// than having its series removed. It's only used by one loop, so isn't locked.
+type repoGauges map[*family]map[string][]gaugeSample
+
+// set replaces the samples of f for slug.
+func (g repoGauges) set(f *family, slug string, samples []gaugeSample) {
+	if g[f] == nil {
+		g[f] = make(map[string][]gaugeSample)
+	}
+	g[f][slug] = samples
+}
+
+// retain forgets every repository not in slugs.
+func (g repoGauges) retain(slugs []string) {
+	keep := make(map[string]bool, len(slugs))
+	for _, slug := range slugs {
+		keep[slug] = true
+	}
+	for _, bySlug := range g {
+		for slug := range bySlug {
+			if !keep[slug] {
+				delete(bySlug, slug)
+			}
+		}
+	}
+}
+
+// samples returns the samples of f across every repository.
+func (g repoGauges) samples(f *family) []gaugeSample {
+	var out []gaugeSample
+	for _, samples := range g[f] {
+		out = append(out, samples...)
+	}
+	return out
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{
+		series:  make(map[*family]map[string]*series),
+		updated: make(map[string]time.Time),
+	}
+}
+
+func seriesKey(labels []string) string {
+	return strings.Join(labels, "\xff")
+}
+
+// get returns the series of f with labels, creating it if needed. labels must
+// already be relabeled and m.mu must be held.
+func (m *metricSet) get(f *family, labels []string) *series {
+	ss, exists := m.series[f]
+	if !exists {
+		ss = make(map[string]*series)
+		m.series[f] = ss
+	}
+	key := seriesKey(labels)
+	s, exists := ss[key]
+	if !exists {
+		s = &series{labels: labels}
+		if f.kind == histogramKind {
+			s.buckets = make([]uint64, len(f.buckets))
+		}
+		ss[key] = s
+	}
+	return s
+}
+
+// inc adds one to the counter of f with labels.
+func (m *metricSet) inc(f *family, labels ...string) {
+	labels, ok := f.relabel(labels)
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.get(f, labels)
+	s.value++
+	s.updated = time.Now()
+}
+
+// set sets the gauge of f with labels to v.
+func (m *metricSet) set(f *family, v float64, labels ...string) {
+	labels, ok := f.relabel(labels)
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.get(f, labels)
+	s.value = v
+	s.updated = time.Now()
+}
+
+// observe records v in the histogram of f with labels.
+func (m *metricSet) observe(f *family, v float64, labels ...string) {
+	labels, ok := f.relabel(labels)
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.get(f, labels)
+	s.updated = time.Now()
+	s.count++
+	s.sum += v
+	for i := range f.buckets {
+		if v <= f.buckets[i] {
+			s.buckets[i]++
+		}
+	}
+}
+
+// replace swaps every gauge of f for samples, so groups which disappear
+// between checks are removed rather than left at a stale value. Samples left
+// with the same labels after relabeling are added up if f sums them, otherwise
+// the last one wins.
+func (m *metricSet) replace(f *family, samples []gaugeSample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.series, f)
+	now := time.Now()
+	for i := range samples {
+		labels, ok := f.relabel(samples[i].labels)
+		if !ok {
+			continue
+		}
+		s := m.get(f, labels)
+		if f.sum {
+			s.value += samples[i].value
+		} else {
+			s.value = samples[i].value
+		}
+		s.updated = now
+	}
+}
+
+// expire removes counter and histogram series which haven't been recorded
+// since cutoff. Gauges are left alone as each check sets or replaces them.
+func (m *metricSet) expire(cutoff time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for f, ss := range m.series {
+		if f.kind == gaugeKind {
+			continue
+		}
+		for k, s := range ss {
+			if s.updated.Before(cutoff) {
+				delete(ss, k)
+			}
+		}
+	}
+}
+
+// touch records that check finished successfully just now.
+func (m *metricSet) touch(check string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.updated[check] = time.Now()
+}
+
+// snapshot is an immutable copy of a metricSet.
+type snapshot struct {
+	series  map[*family]map[string]*series
+	updated map[string]time.Time
+}
+
+// snapshot returns a deep copy of m which is safe to read without locks.
+func (m *metricSet) snapshot() *snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := &snapshot{
+		series:  make(map[*family]map[string]*series),
+		updated: make(map[string]time.Time),
+	}
+	for f, ss := range m.series {
+		cp := make(map[string]*series, len(ss))
+		for k, s := range ss {
+			c := *s
+			if s.buckets != nil {
+				c.buckets = append([]uint64(nil), s.buckets...)
+			}
+			cp[k] = &c
+		}
+		out.series[f] = cp
+	}
+	for k, v := range m.updated {
+		out.updated[k] = v
+	}
+	return out
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenSource supplies the TravisCI token of an organization.
+type tokenSource interface {
+	// token returns the current token
+	token(ctx context.Context) (string, error)
+
+	// invalidate is called when TravisCI rejects the token
+	invalidate()
+
+	// key identifies the token without revealing it, so organizations
+	// sharing a token can share its rate limit.
+	key() string
+}
+
+// newTokenSource returns the tokenSource configured for org.
+func newTokenSource(org organization) tokenSource {
+	switch {
+	case org.TokenFile != "":
+		return &fileToken{path: org.TokenFile}
+	case len(org.TokenCommand) > 0:
+		return &commandToken{command: org.TokenCommand}
+	}
+	secrets.add(org.Token)
+	return staticToken(org.Token)
+}
+
+// staticToken is a token written in our config file.
+type staticToken string
+
+func (t staticToken) token(_ context.Context) (string, error) { return string(t), nil }
+func (t staticToken) invalidate()                             {}
+func (t staticToken) key() string                             { return "token:" + string(t) }
+
+// fileToken reads a token from path, reading it again whenever the file
+// changes (i.e. a Kubernetes secret is rotated).
+type fileToken struct {
+	path string
+
+	mu      sync.Mutex
+	value   string
+	modTime time.Time
+	size    int64
+}
+
+func (t *fileToken) token(_ context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return "", err
+	}
+	if t.value != "" && info.ModTime().Equal(t.modTime) && info.Size() == t.size {
+		return t.value, nil
+	}
+	bs, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(bs))
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", t.path)
+	}
+	secrets.add(value)
+	t.value, t.modTime, t.size = value, info.ModTime(), info.Size()
+	return t.value, nil
+}
+
+func (t *fileToken) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.value = "" // read the file again, even if it looks unchanged
+}
+
+func (t *fileToken) key() string { return "file:" + t.path }
+
+// commandToken runs command for a token, which is kept until TravisCI rejects it.
+type commandToken struct {
+	command []string
+
+	mu    sync.Mutex
+	value string
+}
+
+func (t *commandToken) token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.value != "" {
+		return t.value, nil
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.command[0], t.command[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("token_command %s: %v: %s", t.command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	value := strings.TrimSpace(string(out))
+	if value == "" {
+		return "", fmt.Errorf("token_command %s printed nothing", t.command[0])
+	}
+	secrets.add(value)
+	t.value = value
+	return t.value, nil
+}
+
+func (t *commandToken) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.value = ""
+}
+
+func (t *commandToken) key() string { return "command:" + strings.Join(t.command, " ") }
+
+// tokenTransport authenticates each request with the current token of source.
+type tokenTransport struct {
+	source tokenSource
+	next   http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.token(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("reading travis-ci token: %v", err)
+	}
+
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "token "+token)
+
+	resp, err := t.next.RoundTrip(r)
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		t.source.invalidate()
+	}
+	return resp, err
+}
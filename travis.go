@@ -0,0 +1,222 @@
+// Copyright 2019 Adam Shannon
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/google/go-querystring/query"
+	"github.com/shuheiktgw/go-travis"
+)
+
+// This file holds calls to the TravisCI API which go-travis doesn't offer (or
+// doesn't fully decode). They're built on the exported travis.Client methods.
+
+// pagination is the @pagination block of a collection response, which
+// travis.Metadata doesn't decode.
+//
+// Travis CI API docs: https://developer.travis-ci.com/pagination
+type pagination struct {
+	Limit   int             `json:"limit"`
+	Offset  int             `json:"offset"`
+	Count   int             `json:"count"`
+	IsFirst bool            `json:"is_first"`
+	IsLast  bool            `json:"is_last"`
+	Next    *paginationPage `json:"next"`
+	Last    *paginationPage `json:"last"`
+}
+
+type paginationPage struct {
+	Href   string `json:"@href"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+}
+
+// includeJobs eager loads the standard representation of each build's jobs
+// and repository, so we don't need to look each one up.
+var includeJobs = []string{"build.jobs", "build.repository"}
+
+// buildWithJobs is a travis.Build whose jobs and repository are in their standard
+// representation, as returned when requested with includeJobs.
+type buildWithJobs struct {
+	travis.Build
+
+	Jobs       []travis.Job `json:"jobs,omitempty"`
+	Repository repository   `json:"repository,omitempty"`
+}
+
+// includeCurrentBuild eager loads the most recently started build of each
+// repository, so we only list the builds of repositories which have new ones.
+var includeCurrentBuild = []string{"repository.current_build"}
+
+// repository is the part of a travis.Repository we filter on, along with
+// whether it's a fork which travis.Repository doesn't decode.
+type repository struct {
+	travis.MinimalRepository
+
+	Owner   travis.MinimalOwner `json:"owner"`
+	Active  bool                `json:"active"`
+	Private bool                `json:"private"`
+	Fork    bool                `json:"fork"`
+
+	// CurrentBuild is only set when requested with includeCurrentBuild
+	CurrentBuild *travis.MinimalBuild `json:"current_build,omitempty"`
+}
+
+// buildsOption is a travis.BuildsOption with eager loading of related resources.
+//
+// Travis CI API docs: https://developer.travis-ci.com/eager-loading
+type buildsOption struct {
+	travis.BuildsOption
+
+	// Related resources to load along with each build, i.e. build.jobs
+	Include []string `url:"include,omitempty,comma"`
+}
+
+// includeOption holds eager loading for endpoints without other options.
+type includeOption struct {
+	Include []string `url:"include,omitempty,comma"`
+}
+
+// listRepoBuilds fetches one page of builds for a repository based on the
+// provided slug along with its pagination.
+func listRepoBuilds(ctx context.Context, client *travis.Client, slug string, opt *buildsOption) ([]buildWithJobs, *pagination, *http.Response, error) {
+	u, err := urlWithOptions(fmt.Sprintf("repo/%s/builds", url.QueryEscape(slug)), opt)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	req, err := client.NewRequest(http.MethodGet, u, nil, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var response struct {
+		Builds     []buildWithJobs `json:"builds"`
+		Pagination pagination      `json:"@pagination"`
+	}
+	resp, err := client.Do(ctx, req, &response)
+	if err != nil {
+		return nil, nil, resp, err
+	}
+	return response.Builds, &response.Pagination, resp, nil
+}
+
+// findBuild fetches a build based on the provided id.
+func findBuild(ctx context.Context, client *travis.Client, id uint, opt *includeOption) (*buildWithJobs, *http.Response, error) {
+	u, err := urlWithOptions(fmt.Sprintf("build/%d", id), opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := client.NewRequest(http.MethodGet, u, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var b buildWithJobs
+	resp, err := client.Do(ctx, req, &b)
+	if err != nil {
+		return nil, resp, err
+	}
+	return &b, resp, nil
+}
+
+// findActive fetches the builds currently running for an owner based on the provided login.
+func findActive(ctx context.Context, client *travis.Client, owner string, opt *includeOption) ([]buildWithJobs, *http.Response, error) {
+	u, err := urlWithOptions(fmt.Sprintf("owner/%s/active", url.PathEscape(owner)), opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := client.NewRequest(http.MethodGet, u, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var response struct {
+		Builds []buildWithJobs `json:"builds"`
+	}
+	resp, err := client.Do(ctx, req, &response)
+	if err != nil {
+		return nil, resp, err
+	}
+	return response.Builds, resp, nil
+}
+
+// reposOption pages through an owner's repositories.
+type reposOption struct {
+	Limit  int `url:"limit,omitempty"`
+	Offset int `url:"offset,omitempty"`
+
+	// Related resources to load along with each repository, i.e. repository.current_build
+	Include []string `url:"include,omitempty,comma"`
+}
+
+// listOwnerRepos fetches one page of repositories for an owner based on the
+// provided login along with its pagination.
+func listOwnerRepos(ctx context.Context, client *travis.Client, owner string, opt *reposOption) ([]repository, *pagination, *http.Response, error) {
+	u, err := urlWithOptions(fmt.Sprintf("owner/%s/repos", url.PathEscape(owner)), opt)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	req, err := client.NewRequest(http.MethodGet, u, nil, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var response struct {
+		Repositories []repository `json:"repositories"`
+		Pagination   pagination   `json:"@pagination"`
+	}
+	resp, err := client.Do(ctx, req, &response)
+	if err != nil {
+		return nil, nil, resp, err
+	}
+	return response.Repositories, &response.Pagination, resp, nil
+}
+
+// cache is a travis.Cache with the fields the API returns that go-travis doesn't decode.
+//
+// Travis CI API docs: https://developer.travis-ci.com/resource/caches
+type cache struct {
+	travis.Cache
+
+	// Size of the cache in bytes
+	Size int64 `json:"size"`
+	// When the cache was last modified
+	LastModified string `json:"last_modified"`
+}
+
+// listCaches fetches the caches of a repository based on the provided slug.
+func listCaches(ctx context.Context, client *travis.Client, slug string) ([]cache, *http.Response, error) {
+	req, err := client.NewRequest(http.MethodGet, fmt.Sprintf("repo/%s/caches", url.QueryEscape(slug)), nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var response struct {
+		Caches []cache `json:"caches"`
+	}
+	resp, err := client.Do(ctx, req, &response)
+	if err != nil {
+		return nil, resp, err
+	}
+	return response.Caches, resp, nil
+}
+
+// urlWithOptions adds the url-tagged fields of opt as query parameters to s.
+func urlWithOptions(s string, opt interface{}) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s, err
+	}
+	qs, err := query.Values(opt)
+	if err != nil {
+		return s, err
+	}
+	u.RawQuery = qs.Encode()
+	return u.String(), nil
+}